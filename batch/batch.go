@@ -0,0 +1,67 @@
+package batch
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Batcher collects the events of concurrent pushes and pushes them
+// together, so a sink is not opened for every few events. A batch is pushed
+// when it has size events or wait after its first event, and every push
+// of the batch gets the result.
+type Batcher struct {
+	mu      sync.Mutex
+	size    int
+	wait    time.Duration
+	push    func(items []json.RawMessage) error
+	pending *batch
+}
+
+type batch struct {
+	items []json.RawMessage
+	timer *time.Timer
+	done  chan bool
+	err   error
+}
+
+func New(size int, wait time.Duration, push func(items []json.RawMessage) error) *Batcher {
+	return &Batcher{size: size, wait: wait, push: push}
+}
+
+// Push adds the events to the pending batch and returns when it is pushed.
+func (b *Batcher) Push(items []json.RawMessage) error {
+	b.mu.Lock()
+	current := b.pending
+	if current == nil {
+		current = &batch{done: make(chan bool)}
+		current.timer = time.AfterFunc(b.wait, func() {
+			b.flush(current)
+		})
+		b.pending = current
+	}
+	current.items = append(current.items, items...)
+	full := len(current.items) >= b.size
+	b.mu.Unlock()
+
+	if full {
+		b.flush(current)
+	}
+	<-current.done
+	return current.err
+}
+
+// flush pushes the batch unless it was pushed already.
+func (b *Batcher) flush(current *batch) {
+	b.mu.Lock()
+	if b.pending != current {
+		b.mu.Unlock()
+		return
+	}
+	b.pending = nil
+	current.timer.Stop()
+	b.mu.Unlock()
+
+	current.err = b.push(current.items)
+	close(current.done)
+}
@@ -0,0 +1,69 @@
+package batch
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type batchRecorder struct {
+	sync.Mutex
+	pushes [][]json.RawMessage
+	err    error
+}
+
+func (r *batchRecorder) push(items []json.RawMessage) error {
+	r.Lock()
+	defer r.Unlock()
+	r.pushes = append(r.pushes, items)
+	return r.err
+}
+
+func pushConcurrently(batcher *Batcher, count int) []error {
+	errs := make([]error, count)
+	var wg sync.WaitGroup
+	for index := 0; index < count; index++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			errs[index] = batcher.Push([]json.RawMessage{json.RawMessage(`{"id":"1"}`)})
+		}(index)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestConcurrentPushesPushedAsOneBatch(t *testing.T) {
+	recorder := &batchRecorder{}
+	batcher := New(3, time.Hour, recorder.push)
+
+	errs := pushConcurrently(batcher, 3)
+
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+	assert.Len(t, recorder.pushes, 1)
+	assert.Len(t, recorder.pushes[0], 3)
+}
+
+func TestPartialBatchPushedAfterWait(t *testing.T) {
+	recorder := &batchRecorder{}
+	batcher := New(100, 10*time.Millisecond, recorder.push)
+
+	err := batcher.Push([]json.RawMessage{json.RawMessage(`{"id":"1"}`)})
+
+	assert.Nil(t, err)
+	assert.Len(t, recorder.pushes, 1)
+}
+
+func TestFailedBatchFailsEveryPush(t *testing.T) {
+	recorder := &batchRecorder{err: errors.New("Failed to connect to remote host.")}
+	batcher := New(2, time.Hour, recorder.push)
+
+	errs := pushConcurrently(batcher, 2)
+
+	assert.Equal(t, []error{recorder.err, recorder.err}, errs)
+	assert.Len(t, recorder.pushes, 1)
+}
@@ -0,0 +1,133 @@
+package enrich
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"matchwork/mailgun-log-fetcher/event"
+	"matchwork/mailgun-log-fetcher/settings"
+)
+
+var reloadFailures = expvar.NewInt("enrich_reload_failures")
+
+type lookup struct {
+	keys   []string
+	target string
+	table  *table
+}
+
+// Enrich adds the rows of local lookup tables to every event, like the
+// account of the recipient domain or the campaign of a tag.
+type Enrich struct {
+	lookups []lookup
+}
+
+// New reads the tables listed in ENRICH_TABLES, every table reads its
+// settings prefixed with its upper cased name (i.e. ACCOUNTS_FILE).
+func New(config func(key string) string) *Enrich {
+	enrich := &Enrich{}
+	for _, name := range settings.List(config("ENRICH_TABLES")) {
+		prefix := settings.Prefix(name)
+		file := config(prefix + "FILE")
+		if file == "" {
+			panic(fmt.Sprintf("%sFILE is not set.", prefix))
+		}
+		keys := settings.List(config(prefix + "KEY"))
+		if len(keys) == 0 {
+			panic(fmt.Sprintf("%sKEY is not set.", prefix))
+		}
+		target := config(prefix + "TARGET")
+		if target == "" {
+			target = name
+		}
+
+		table, err := newTable(file, config(prefix+"COLUMN"))
+		if err != nil {
+			panic(fmt.Sprintf("Failed to read lookup table %s. %s", name, err))
+		}
+		enrich.lookups = append(enrich.lookups, lookup{keys: keys, target: target, table: table})
+	}
+	return enrich
+}
+
+// Apply returns the enriched events, the events themselves without tables.
+func (e *Enrich) Apply(items []json.RawMessage) []json.RawMessage {
+	if len(e.lookups) == 0 {
+		return items
+	}
+	tables := make([]map[string]interface{}, len(e.lookups))
+	for index, lookup := range e.lookups {
+		tables[index] = lookup.table.current()
+	}
+
+	enriched := make([]json.RawMessage, len(items))
+	for index, item := range items {
+		enriched[index] = e.apply(item, tables)
+	}
+	return enriched
+}
+
+func (e *Enrich) apply(item json.RawMessage, tables []map[string]interface{}) json.RawMessage {
+	document := event.Decode(item)
+	if document == nil {
+		return item
+	}
+
+	changed := false
+	for index, lookup := range e.lookups {
+		if row, ok := find(document, lookup.keys, tables[index]); ok {
+			event.Set(document, lookup.target, copyValue(row))
+			changed = true
+		}
+	}
+	if !changed {
+		return item
+	}
+
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return item
+	}
+	return encoded
+}
+
+// find returns the row of the first key path with a value in the table.
+// The values of a list (i.e. tags) are tried one by one.
+func find(document map[string]interface{}, keys []string, rows map[string]interface{}) (interface{}, bool) {
+	for _, path := range keys {
+		value, ok := event.Lookup(document, path)
+		if !ok || value == nil {
+			continue
+		}
+		values, isList := value.([]interface{})
+		if !isList {
+			values = []interface{}{value}
+		}
+		for _, value := range values {
+			if row, ok := rows[normalizeKey(fmt.Sprint(value))]; ok {
+				return row, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// copyValue copies the objects and lists of a row, so setting a path into
+// an event does not change the table.
+func copyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			copied[key] = copyValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for index, item := range value {
+			copied[index] = copyValue(item)
+		}
+		return copied
+	}
+	return value
+}
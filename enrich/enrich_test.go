@@ -0,0 +1,92 @@
+package enrich
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var item = json.RawMessage(`{"id":"1","event":"delivered","recipient":"a@example.com","recipient-domain":"Example.com","tags":["newsletter","autumn-sale"],"user-variables":{"account":"A-2"}}`)
+
+func config(values map[string]string) func(key string) string {
+	return func(key string) string {
+		return values[key]
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, ioutil.WriteFile(file, []byte(content), 0644))
+	return file
+}
+
+func TestUnconfiguredEnrichKeepsItems(t *testing.T) {
+	items := []json.RawMessage{json.RawMessage(`{"id": "1"}`)}
+
+	assert.Equal(t, items, New(config(nil)).Apply(items))
+}
+
+func TestEventsEnrichedFromTables(t *testing.T) {
+	enrich := New(config(map[string]string{
+		"ENRICH_TABLES":    "accounts,campaigns",
+		"ACCOUNTS_FILE":    writeFile(t, "accounts.csv", "domain,account_id,team\nexample.com,A-1,billing\n"),
+		"ACCOUNTS_KEY":     "recipient-domain",
+		"CAMPAIGNS_FILE":   writeFile(t, "campaigns.json", `{"autumn-sale":"Autumn sale 2026"}`),
+		"CAMPAIGNS_KEY":    "tags",
+		"CAMPAIGNS_TARGET": "labels.campaign",
+	}))
+
+	enriched := enrich.Apply([]json.RawMessage{item, json.RawMessage(`{"id":"2","recipient-domain":"example.org"}`)})
+
+	assert.JSONEq(t, `{"id":"1","event":"delivered","recipient":"a@example.com","recipient-domain":"Example.com","tags":["newsletter","autumn-sale"],"user-variables":{"account":"A-2"},`+
+		`"accounts":{"account_id":"A-1","team":"billing"},"labels":{"campaign":"Autumn sale 2026"}}`, string(enriched[0]))
+	assert.Equal(t, `{"id":"2","recipient-domain":"example.org"}`, string(enriched[1]))
+}
+
+func TestFirstKeyWithRowUsed(t *testing.T) {
+	enrich := New(config(map[string]string{
+		"ENRICH_TABLES":  "account",
+		"ACCOUNT_FILE":   writeFile(t, "accounts.json", `[{"id":"A-2","owner":"team-eu"},{"id":"example.com","owner":"team-us"}]`),
+		"ACCOUNT_KEY":    "user-variables.missing,user-variables.account,recipient-domain",
+		"ACCOUNT_COLUMN": "id",
+	}))
+
+	enriched := enrich.Apply([]json.RawMessage{item})
+
+	var document map[string]interface{}
+	assert.Nil(t, json.Unmarshal(enriched[0], &document))
+	assert.Equal(t, map[string]interface{}{"owner": "team-eu"}, document["account"])
+}
+
+func TestTargetsInsideRowsDoNotChangeTables(t *testing.T) {
+	enrich := New(config(map[string]string{
+		"ENRICH_TABLES": "account,team",
+		"ACCOUNT_FILE":  writeFile(t, "accounts.csv", "domain,id\nexample.com,A-1\n"),
+		"ACCOUNT_KEY":   "recipient-domain",
+		"TEAM_FILE":     writeFile(t, "teams.csv", "id,team\nA-1,billing\n"),
+		"TEAM_KEY":      "account.id",
+		"TEAM_TARGET":   "account.owner",
+	}))
+
+	first := enrich.Apply([]json.RawMessage{item})
+	second := enrich.Apply([]json.RawMessage{json.RawMessage(`{"recipient-domain":"example.com"}`)})
+
+	assert.Contains(t, string(first[0]), `"account":{"id":"A-1","owner":{"team":"billing"}}`)
+	assert.Equal(t, `{"account":{"id":"A-1","owner":{"team":"billing"}},"recipient-domain":"example.com"}`, string(second[0]))
+	assert.Equal(t, map[string]interface{}{"id": "A-1"}, enrich.lookups[0].table.current()["example.com"])
+}
+
+func TestMissingSettingsPanic(t *testing.T) {
+	assert.PanicsWithValue(t, "ACCOUNTS_FILE is not set.", func() {
+		New(config(map[string]string{"ENRICH_TABLES": "accounts", "ACCOUNTS_KEY": "recipient-domain"}))
+	})
+	assert.PanicsWithValue(t, "ACCOUNTS_KEY is not set.", func() {
+		New(config(map[string]string{"ENRICH_TABLES": "accounts", "ACCOUNTS_FILE": "accounts.csv"}))
+	})
+	assert.Panics(t, func() {
+		New(config(map[string]string{"ENRICH_TABLES": "accounts", "ACCOUNTS_FILE": "missing.csv", "ACCOUNTS_KEY": "recipient-domain"}))
+	})
+}
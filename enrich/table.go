@@ -0,0 +1,160 @@
+package enrich
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// table is a lookup table read from a CSV or JSON file, read again when the
+// file changes.
+type table struct {
+	file   string
+	column string
+
+	mutex    sync.Mutex
+	modified time.Time
+	size     int64
+	rows     map[string]interface{}
+}
+
+func newTable(file string, column string) (*table, error) {
+	t := &table{file: file, column: column}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	return t, t.load(info)
+}
+
+// current returns the rows, read again when the file changed. A change
+// which cannot be read keeps the rows read before, until the next change.
+func (t *table) current() map[string]interface{} {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	info, err := os.Stat(t.file)
+	if err != nil || info.ModTime().Equal(t.modified) && info.Size() == t.size {
+		return t.rows
+	}
+	if err := t.load(info); err != nil {
+		reloadFailures.Add(1)
+		log.Printf("Failed to reload lookup table %s, using the previous one. %s", t.file, err)
+		t.modified = info.ModTime()
+		t.size = info.Size()
+	}
+	return t.rows
+}
+
+func (t *table) load(info os.FileInfo) error {
+	content, err := ioutil.ReadFile(t.file)
+	if err != nil {
+		return err
+	}
+
+	var rows map[string]interface{}
+	if strings.ToLower(filepath.Ext(t.file)) == ".json" {
+		rows, err = jsonRows(content, t.column)
+	} else {
+		rows, err = csvRows(content, t.column)
+	}
+	if err != nil {
+		return err
+	}
+
+	t.rows = rows
+	t.modified = info.ModTime()
+	t.size = info.Size()
+	return nil
+}
+
+// csvRows reads a CSV file with a header line, every row is an object of
+// its other columns keyed by the key column, the first one by default.
+func csvRows(content []byte, column string) (map[string]interface{}, error) {
+	records, err := csv.NewReader(strings.NewReader(string(content))).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("The header line is missing")
+	}
+
+	header := records[0]
+	keyIndex := 0
+	if column != "" {
+		keyIndex = indexOf(header, column)
+		if keyIndex < 0 {
+			return nil, fmt.Errorf("Key column %s not found", column)
+		}
+	}
+
+	rows := map[string]interface{}{}
+	for _, record := range records[1:] {
+		row := map[string]interface{}{}
+		for index, value := range record {
+			if index != keyIndex {
+				row[header[index]] = value
+			}
+		}
+		rows[normalizeKey(record[keyIndex])] = row
+	}
+	return rows, nil
+}
+
+// jsonRows reads an object of rows by key, or an array of objects keyed by
+// the key column, "key" by default.
+func jsonRows(content []byte, column string) (map[string]interface{}, error) {
+	var document interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(content)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	rows := map[string]interface{}{}
+	switch document := document.(type) {
+	case map[string]interface{}:
+		for key, row := range document {
+			rows[normalizeKey(key)] = row
+		}
+	case []interface{}:
+		if column == "" {
+			column = "key"
+		}
+		for index, row := range document {
+			object, ok := row.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Row %d is not an object", index+1)
+			}
+			key, ok := object[column]
+			if !ok {
+				return nil, fmt.Errorf("Row %d has no %s", index+1, column)
+			}
+			delete(object, column)
+			rows[normalizeKey(fmt.Sprint(key))] = object
+		}
+	default:
+		return nil, fmt.Errorf("Expected an object or an array of objects")
+	}
+	return rows, nil
+}
+
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.TrimSpace(key))
+}
+
+func indexOf(values []string, value string) int {
+	for index, candidate := range values {
+		if candidate == value {
+			return index
+		}
+	}
+	return -1
+}
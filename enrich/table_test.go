@@ -0,0 +1,52 @@
+package enrich
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCsvRowsKeyedByColumn(t *testing.T) {
+	rows, err := csvRows([]byte("name,domain,account\nExample,Example.com ,A-1\n"), "domain")
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"example.com": map[string]interface{}{"name": "Example", "account": "A-1"}}, rows)
+
+	_, err = csvRows([]byte("name,domain\n"), "account")
+	assert.EqualError(t, err, "Key column account not found")
+}
+
+func TestJsonRowsKeyedByColumn(t *testing.T) {
+	rows, err := jsonRows([]byte(`[{"key":"invoice","campaign":"Invoices","budget":12.50}]`), "")
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"campaign": "Invoices", "budget": json.Number("12.50")}, rows["invoice"])
+
+	_, err = jsonRows([]byte(`["invoice"]`), "")
+	assert.EqualError(t, err, "Row 1 is not an object")
+	_, err = jsonRows([]byte(`"invoice"`), "")
+	assert.EqualError(t, err, "Expected an object or an array of objects")
+}
+
+func TestTableReloadedOnChange(t *testing.T) {
+	file := writeFile(t, "teams.csv", "tag,team\ninvoice,billing\n")
+	table, err := newTable(file, "")
+	assert.Nil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(file, []byte("tag,team\ninvoice,finance\n"), 0644))
+	assert.Nil(t, os.Chtimes(file, time.Now(), time.Now().Add(time.Minute)))
+	assert.Equal(t, map[string]interface{}{"team": "finance"}, table.current()["invoice"])
+
+	failures := reloadFailures.Value()
+	assert.Nil(t, ioutil.WriteFile(file, []byte("tag,team\ninvoice\"\n"), 0644))
+	assert.Nil(t, os.Chtimes(file, time.Now(), time.Now().Add(2*time.Minute)))
+	assert.Equal(t, map[string]interface{}{"team": "finance"}, table.current()["invoice"])
+	assert.Equal(t, failures+1, reloadFailures.Value())
+
+	table.current()
+	assert.Equal(t, failures+1, reloadFailures.Value())
+}
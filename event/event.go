@@ -0,0 +1,129 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const SeverityError = 3
+const SeverityWarning = 4
+const SeverityInformational = 6
+
+// Event is the typed part of a Mailgun event used by the sinks, see
+// https://documentation.mailgun.com/en/latest/api-events.html#event-structure
+type Event struct {
+	ID              string  `json:"id"`
+	Timestamp       float64 `json:"timestamp"`
+	Event           string  `json:"event"`
+	LogLevel        string  `json:"log-level"`
+	FailureSeverity string  `json:"severity"`
+	Recipient       string  `json:"recipient"`
+	RecipientDomain string  `json:"recipient-domain"`
+}
+
+// Parse reads the typed fields of an event. Fields missing or having an
+// unexpected type are left empty.
+func Parse(item json.RawMessage) Event {
+	var e Event
+	json.Unmarshal(item, &e)
+	return e
+}
+
+// Time is the timestamp of the event, Mailgun has microsecond precision.
+func (e Event) Time() time.Time {
+	seconds, fraction := math.Modf(e.Timestamp)
+	return time.Unix(int64(seconds), int64(math.Round(fraction*1e6))*1e3).UTC()
+}
+
+// Decode reads an event as a generic document for the lookup of any field.
+// Numbers are kept as json.Number, so they are not rounded.
+func Decode(item json.RawMessage) map[string]interface{} {
+	var document map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(item)))
+	decoder.UseNumber()
+	decoder.Decode(&document)
+	return document
+}
+
+// Lookup returns the value at a dot separated path like message.headers.to.
+func Lookup(document map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = document
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = object[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Set puts value at a dot separated path, creating the missing objects on
+// the way. A value which is not an object on the path is replaced.
+func Set(document map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	object := document
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := object[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			object[key] = next
+		}
+		object = next
+	}
+	object[keys[len(keys)-1]] = value
+}
+
+// Remove deletes the value at a dot separated path and returns it.
+func Remove(document map[string]interface{}, path string) (interface{}, bool) {
+	keys := strings.Split(path, ".")
+	parent, ok := Lookup(document, strings.Join(keys[:len(keys)-1], "."))
+	if len(keys) == 1 {
+		parent, ok = document, true
+	}
+	object, isObject := parent.(map[string]interface{})
+	if !ok || !isObject {
+		return nil, false
+	}
+	value, ok := object[keys[len(keys)-1]]
+	delete(object, keys[len(keys)-1])
+	return value, ok
+}
+
+// LookupString returns the value at path formatted as text, empty when it
+// is missing or null. Objects and arrays are returned as JSON.
+func LookupString(document map[string]interface{}, path string) string {
+	value, ok := Lookup(document, path)
+	if !ok || value == nil {
+		return ""
+	}
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}
+	return fmt.Sprint(value)
+}
+
+// Severity is the syslog severity of the event: error for permanent failures
+// and rejects, warning for temporary failures and complaints, and the
+// log-level of Mailgun otherwise.
+func (e Event) Severity() int {
+	switch {
+	case e.FailureSeverity == "permanent" || e.Event == "rejected":
+		return SeverityError
+	case e.FailureSeverity == "temporary" || e.Event == "complained":
+		return SeverityWarning
+	case e.LogLevel == "error":
+		return SeverityError
+	case e.LogLevel == "warn":
+		return SeverityWarning
+	}
+	return SeverityInformational
+}
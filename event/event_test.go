@@ -0,0 +1,77 @@
+package event
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEventParsed(t *testing.T) {
+	e := Parse(json.RawMessage(`{"id":"ZYqv-femT-eZdNugraadzQ","event":"clicked","timestamp":1636532241.823582}`))
+
+	if e.ID != "ZYqv-femT-eZdNugraadzQ" || e.Event != "clicked" {
+		t.Errorf("Event fields expected. %v", e)
+	}
+	if e.Time().Format(time.RFC3339Nano) != "2021-11-10T08:17:21.823582Z" {
+		t.Errorf("Event time expected, got %s", e.Time().Format(time.RFC3339Nano))
+	}
+}
+
+func TestInvalidEventLeftEmpty(t *testing.T) {
+	e := Parse(json.RawMessage(`{"id":1,"timestamp":"now"}`))
+
+	if e.ID != "" || e.Timestamp != 0 {
+		t.Errorf("Empty event expected. %v", e)
+	}
+}
+
+func TestFieldLookedUpByPath(t *testing.T) {
+	document := Decode(json.RawMessage(`{"message":{"headers":{"to":"info@example.com"},"size":50994},"tags":["a","b"],"flags":null}`))
+
+	if LookupString(document, "message.headers.to") != "info@example.com" {
+		t.Errorf("Nested field expected.")
+	}
+	if LookupString(document, "message.size") != "50994" {
+		t.Errorf("Number field expected.")
+	}
+	if LookupString(document, "tags") != `["a","b"]` {
+		t.Errorf("Array as json expected.")
+	}
+	if LookupString(document, "flags") != "" || LookupString(document, "message.headers.to.name") != "" {
+		t.Errorf("Empty value expected for null and missing field.")
+	}
+}
+
+func TestFieldSetAndRemovedByPath(t *testing.T) {
+	document := Decode(json.RawMessage(`{"recipient":"a@example.com","message":{"headers":{"to":"a@example.com"}}}`))
+
+	Set(document, "message.headers.subject", "Hello")
+	Set(document, "envelope.targets", "a@example.com")
+	removed, ok := Remove(document, "message.headers.to")
+	_, missing := Remove(document, "recipient.domain")
+
+	encoded, _ := json.Marshal(document)
+	if string(encoded) != `{"envelope":{"targets":"a@example.com"},"message":{"headers":{"subject":"Hello"}},"recipient":"a@example.com"}` {
+		t.Errorf("Changed document expected, got %s", encoded)
+	}
+	if !ok || removed != "a@example.com" || missing {
+		t.Errorf("Removed value expected, got %v", removed)
+	}
+}
+
+func TestSeverityOfEvents(t *testing.T) {
+	cases := map[string]int{
+		`{"event":"failed","severity":"permanent","log-level":"error"}`: SeverityError,
+		`{"event":"failed","severity":"temporary","log-level":"warn"}`:  SeverityWarning,
+		`{"event":"rejected"}`:                     SeverityError,
+		`{"event":"complained"}`:                   SeverityWarning,
+		`{"event":"delivered","log-level":"info"}`: SeverityInformational,
+		`{"event":"stored","log-level":"warn"}`:    SeverityWarning,
+	}
+
+	for item, expected := range cases {
+		if severity := Parse(json.RawMessage(item)).Severity(); severity != expected {
+			t.Errorf("Severity %d expected for %s, got %d", expected, item, severity)
+		}
+	}
+}
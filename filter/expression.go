@@ -0,0 +1,402 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"matchwork/mailgun-log-fetcher/event"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a condition on the fields of an event, like
+// event == "failed" and severity == "permanent" and tags contains "invoice".
+type Expression interface {
+	Match(document map[string]interface{}) bool
+}
+
+type and struct{ left, right Expression }
+type or struct{ left, right Expression }
+type not struct{ operand Expression }
+type constant bool
+
+type comparison struct {
+	path     string
+	operator string
+	values   []interface{}
+	pattern  *regexp.Regexp
+}
+
+func (e and) Match(document map[string]interface{}) bool {
+	return e.left.Match(document) && e.right.Match(document)
+}
+
+func (e or) Match(document map[string]interface{}) bool {
+	return e.left.Match(document) || e.right.Match(document)
+}
+
+func (e not) Match(document map[string]interface{}) bool {
+	return !e.operand.Match(document)
+}
+
+func (e constant) Match(document map[string]interface{}) bool {
+	return bool(e)
+}
+
+func (e comparison) Match(document map[string]interface{}) bool {
+	field, ok := event.Lookup(document, e.path)
+	switch e.operator {
+	case "exists":
+		return ok && field != nil
+	case "":
+		return field == true
+	case "!=":
+		return !equal(field, e.values[0])
+	case "==":
+		return equal(field, e.values[0])
+	case "contains":
+		if list, isList := field.([]interface{}); isList {
+			for _, element := range list {
+				if equal(element, e.values[0]) {
+					return true
+				}
+			}
+			return false
+		}
+		text, isText := field.(string)
+		value, isTextValue := e.values[0].(string)
+		return isText && isTextValue && strings.Contains(text, value)
+	}
+
+	for _, element := range elements(field) {
+		if e.matchElement(element) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchElement compares one value of the field, every value of a list
+// field is compared for in, matches and the orderings.
+func (e comparison) matchElement(field interface{}) bool {
+	switch e.operator {
+	case "in":
+		for _, value := range e.values {
+			if equal(field, value) {
+				return true
+			}
+		}
+		return false
+	case "matches":
+		text, ok := field.(string)
+		return ok && e.pattern.MatchString(text)
+	}
+
+	order, ok := compare(field, e.values[0])
+	if !ok {
+		return false
+	}
+	switch e.operator {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	}
+	return order >= 0
+}
+
+func elements(field interface{}) []interface{} {
+	if list, ok := field.([]interface{}); ok {
+		return list
+	}
+	return []interface{}{field}
+}
+
+func equal(field interface{}, value interface{}) bool {
+	if order, ok := compare(field, value); ok {
+		return order == 0
+	}
+	return field == value
+}
+
+// compare orders numbers by value and strings lexically, other values are
+// not ordered.
+func compare(field interface{}, value interface{}) (int, bool) {
+	if left, ok := number(field); ok {
+		if right, ok := number(value); ok {
+			switch {
+			case left < right:
+				return -1, true
+			case left > right:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	left, leftText := field.(string)
+	right, rightText := value.(string)
+	if leftText && rightText {
+		return strings.Compare(left, right), true
+	}
+	return 0, false
+}
+
+func number(value interface{}) (float64, bool) {
+	switch typed := value.(type) {
+	case json.Number:
+		parsed, err := typed.Float64()
+		return parsed, err == nil
+	case float64:
+		return typed, true
+	}
+	return 0, false
+}
+
+var operators = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+type token struct {
+	kind  string
+	text  string
+	value interface{}
+}
+
+const (
+	tokenPath      = "path"
+	tokenValue     = "value"
+	tokenSymbol    = "symbol"
+	tokenSeparator = "separator"
+	tokenEnd       = "end"
+)
+
+// tokenize splits rules to tokens. Newlines and semicolons separate rules.
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+	runes := []rune(text)
+	for index := 0; index < len(runes); {
+		r := runes[index]
+		switch {
+		case r == '\n' || r == ';':
+			tokens = append(tokens, token{kind: tokenSeparator, text: string(r)})
+			index++
+		case unicode.IsSpace(r):
+			index++
+		case r == '#':
+			for index < len(runes) && runes[index] != '\n' {
+				index++
+			}
+		case r == '"':
+			end := index + 1
+			for end < len(runes) && runes[end] != '"' {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("Unterminated string %s", string(runes[index:]))
+			}
+			value, err := strconv.Unquote(string(runes[index : end+1]))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid string %s", string(runes[index:end+1]))
+			}
+			tokens = append(tokens, token{kind: tokenValue, text: string(runes[index : end+1]), value: value})
+			index = end + 1
+		case strings.ContainsRune("=!<>", r):
+			operator := string(r)
+			if index+1 < len(runes) && runes[index+1] == '=' {
+				operator += "="
+			}
+			if operator == "=" || operator == "!" {
+				return nil, fmt.Errorf("Unknown operator %s", operator)
+			}
+			tokens = append(tokens, token{kind: tokenSymbol, text: operator})
+			index += len(operator)
+		case strings.ContainsRune("()[],", r):
+			tokens = append(tokens, token{kind: tokenSymbol, text: string(r)})
+			index++
+		case unicode.IsDigit(r) || r == '-' && index+1 < len(runes) && unicode.IsDigit(runes[index+1]):
+			end := index + 1
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			text := string(runes[index:end])
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, fmt.Errorf("Invalid number %s", text)
+			}
+			tokens = append(tokens, token{kind: tokenValue, text: text, value: json.Number(text)})
+			index = end
+		case unicode.IsLetter(r) || r == '_':
+			end := index + 1
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || strings.ContainsRune("_-.", runes[end])) {
+				end++
+			}
+			text := string(runes[index:end])
+			switch text {
+			case "true":
+				tokens = append(tokens, token{kind: tokenValue, text: text, value: true})
+			case "false":
+				tokens = append(tokens, token{kind: tokenValue, text: text, value: false})
+			case "null":
+				tokens = append(tokens, token{kind: tokenValue, text: text, value: nil})
+			default:
+				tokens = append(tokens, token{kind: tokenPath, text: text})
+			}
+			index = end
+		default:
+			return nil, fmt.Errorf("Unexpected character %q", r)
+		}
+	}
+	return append(tokens, token{kind: tokenEnd, text: "end of rule"}), nil
+}
+
+// parser is a recursive descent parser of the grammar
+//
+//	expression := term { "or" term }
+//	term       := factor { "and" factor }
+//	factor     := "not" factor | "(" expression ")" | true | false | condition
+//	condition  := path [ "exists" | operator value | "in" "[" value { "," value } "]" ]
+type parser struct {
+	tokens []token
+	index  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.index]
+}
+
+func (p *parser) next() token {
+	current := p.tokens[p.index]
+	if current.kind != tokenEnd {
+		p.index++
+	}
+	return current
+}
+
+func (p *parser) keyword(text string) bool {
+	if current := p.peek(); current.kind == tokenPath && current.text == text {
+		p.index++
+		return true
+	}
+	return false
+}
+
+func (p *parser) symbol(text string) bool {
+	if current := p.peek(); current.kind == tokenSymbol && current.text == text {
+		p.index++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expression() (Expression, error) {
+	left, err := p.term()
+	for err == nil && p.keyword("or") {
+		var right Expression
+		if right, err = p.term(); err == nil {
+			left = or{left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) term() (Expression, error) {
+	left, err := p.factor()
+	for err == nil && p.keyword("and") {
+		var right Expression
+		if right, err = p.factor(); err == nil {
+			left = and{left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) factor() (Expression, error) {
+	if p.keyword("not") {
+		operand, err := p.factor()
+		return not{operand: operand}, err
+	}
+	if p.symbol("(") {
+		inner, err := p.expression()
+		if err == nil && !p.symbol(")") {
+			err = fmt.Errorf("Expected ) instead of %s", p.peek().text)
+		}
+		return inner, err
+	}
+	if current := p.peek(); current.kind == tokenValue {
+		if boolean, ok := current.value.(bool); ok {
+			p.next()
+			return constant(boolean), nil
+		}
+	}
+
+	path := p.next()
+	if path.kind != tokenPath {
+		return nil, fmt.Errorf("Expected a field instead of %s", path.text)
+	}
+	condition := comparison{path: path.text}
+	switch {
+	case p.keyword("exists"):
+		condition.operator = "exists"
+	case p.keyword("in"):
+		condition.operator = "in"
+		if !p.symbol("[") {
+			return nil, fmt.Errorf("Expected [ after in instead of %s", p.peek().text)
+		}
+		for {
+			value := p.next()
+			if value.kind != tokenValue {
+				return nil, fmt.Errorf("Expected a value instead of %s", value.text)
+			}
+			condition.values = append(condition.values, value.value)
+			if p.symbol("]") {
+				break
+			}
+			if !p.symbol(",") {
+				return nil, fmt.Errorf("Expected , or ] instead of %s", p.peek().text)
+			}
+		}
+	case p.keyword("contains"), p.keyword("matches"):
+		condition.operator = p.tokens[p.index-1].text
+	case p.peek().kind == tokenSymbol && operators[p.peek().text]:
+		condition.operator = p.next().text
+	default:
+		return condition, nil
+	}
+
+	if condition.values == nil && condition.operator != "exists" {
+		value := p.next()
+		if value.kind != tokenValue {
+			return nil, fmt.Errorf("Expected a value after %s instead of %s", condition.operator, value.text)
+		}
+		condition.values = []interface{}{value.value}
+	}
+	if condition.operator == "matches" {
+		text, ok := condition.values[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("Expected a regular expression after matches")
+		}
+		pattern, err := regexp.Compile(text)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression %s. %s", text, err)
+		}
+		condition.pattern = pattern
+	}
+	return condition, nil
+}
+
+// ParseExpression parses one expression, like the condition of a rule.
+func ParseExpression(text string) (Expression, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expression, err := p.expression()
+	if err == nil && p.peek().kind != tokenEnd {
+		err = fmt.Errorf("Unexpected %s", p.peek().text)
+	}
+	return expression, err
+}
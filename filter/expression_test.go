@@ -0,0 +1,74 @@
+package filter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"matchwork/mailgun-log-fetcher/event"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var failed = event.Decode(json.RawMessage(`{"event":"failed","severity":"permanent","recipient":"a@example.com","tags":["invoice","eu"],"delivery-status":{"code":550},"flags":{"is-test-mode":false}}`))
+
+func matches(t *testing.T, text string) bool {
+	expression, err := ParseExpression(text)
+	assert.Nil(t, err, text)
+	return expression.Match(failed)
+}
+
+func TestComparisonsMatched(t *testing.T) {
+	for _, text := range []string{
+		`event == "failed"`,
+		`event != "delivered"`,
+		`delivery-status.code >= 500`,
+		`delivery-status.code < 600.5`,
+		`tags contains "invoice"`,
+		`recipient contains "@example"`,
+		`event in ["failed", "rejected"]`,
+		`tags in ["eu", "us"]`,
+		`recipient matches "^a@.*\\.com$"`,
+		`severity exists`,
+		`not flags.is-test-mode`,
+		`missing != "x"`,
+		`true`,
+	} {
+		assert.True(t, matches(t, text), text)
+	}
+}
+
+func TestComparisonsNotMatched(t *testing.T) {
+	for _, text := range []string{
+		`event == "delivered"`,
+		`delivery-status.code > 550`,
+		`delivery-status.code == "550"`,
+		`tags contains "us"`,
+		`event in ["delivered"]`,
+		`missing exists`,
+		`flags.is-test-mode`,
+		`false`,
+	} {
+		assert.False(t, matches(t, text), text)
+	}
+}
+
+func TestBooleanOperatorsPrecedence(t *testing.T) {
+	assert.True(t, matches(t, `event == "delivered" or event == "failed" and severity == "permanent"`))
+	assert.False(t, matches(t, `(event == "delivered" or event == "failed") and severity == "temporary"`))
+	assert.True(t, matches(t, `not (event == "delivered" or severity == "temporary")`))
+}
+
+func TestInvalidExpressionsFail(t *testing.T) {
+	for text, message := range map[string]string{
+		`event = "failed"`:        "Unknown operator =",
+		`event == `:               "Expected a value after == instead of end of rule",
+		`event in "failed"`:       "Expected [ after in instead of \"failed\"",
+		`(event == "failed"`:      "Expected ) instead of end of rule",
+		`recipient matches "("`:   "Invalid regular expression (. error parsing regexp: missing closing ): `(`",
+		`event == "failed" extra`: "Unexpected extra",
+		`event == "unterminated`:  "Unterminated string \"unterminated",
+	} {
+		_, err := ParseExpression(text)
+		assert.EqualError(t, err, message, text)
+	}
+}
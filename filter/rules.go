@@ -0,0 +1,264 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"matchwork/mailgun-log-fetcher/event"
+	"matchwork/mailgun-log-fetcher/settings"
+	"strings"
+)
+
+const (
+	actionDrop  = "drop"
+	actionKeep  = "keep"
+	actionRoute = "route"
+)
+
+type Rule struct {
+	Action     string
+	Sinks      []string
+	Expression Expression
+}
+
+// RouteField carries the sinks chosen by the route rules with an event to
+// the fan-out, which removes it before pushing the event.
+const RouteField = "_route"
+
+// Rules decide which events are pushed and to which sinks of a fan-out.
+// The first drop or keep rule matching an event decides whether it is
+// pushed, an event no such rule matches is pushed. The first route rule
+// matching an event lists its sinks, an event no route rule matches goes
+// to every sink. Both decide on the event as Mailgun sent it.
+type Rules struct {
+	rules []Rule
+}
+
+// Parse reads rules like
+//
+//	drop event == "opened"
+//	route siem event in ["complained", "failed"]
+//
+// one per line or separated by semicolons, # starts a comment.
+func Parse(text string) (*Rules, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := &Rules{}
+	var current []token
+	for _, t := range tokens {
+		if t.kind != tokenSeparator && t.kind != tokenEnd {
+			current = append(current, t)
+			continue
+		}
+		if len(current) > 0 {
+			rule, err := parseRule(append(current, token{kind: tokenEnd, text: "end of rule"}))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid rule %d: %s", len(rules.rules)+1, err)
+			}
+			rules.rules = append(rules.rules, rule)
+		}
+		current = nil
+	}
+	return rules, nil
+}
+
+func parseRule(tokens []token) (Rule, error) {
+	p := &parser{tokens: tokens}
+	rule := Rule{Action: p.next().text}
+	switch rule.Action {
+	case actionDrop, actionKeep:
+	case actionRoute:
+		for {
+			sink := p.next()
+			if sink.kind != tokenPath {
+				return rule, fmt.Errorf("Expected a sink name instead of %s", sink.text)
+			}
+			rule.Sinks = append(rule.Sinks, sink.text)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	default:
+		return rule, fmt.Errorf("Unknown action %s", rule.Action)
+	}
+
+	var err error
+	rule.Expression, err = p.expression()
+	if err == nil && p.peek().kind != tokenEnd {
+		err = fmt.Errorf("Unexpected %s", p.peek().text)
+	}
+	return rule, err
+}
+
+// FromConfig parses FILTER_RULES, or the rules in FILTER_RULES_FILE.
+func FromConfig(config func(key string) string) *Rules {
+	text := config("FILTER_RULES")
+	if file := config("FILTER_RULES_FILE"); file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to read filter rules. %s", err))
+		}
+		text = string(content)
+	}
+	rules, err := Parse(text)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse filter rules. %s", err))
+	}
+	return rules
+}
+
+// Keep tells whether the event is pushed.
+func (r *Rules) Keep(document map[string]interface{}) bool {
+	for _, rule := range r.rules {
+		if rule.Action != actionRoute && rule.Expression.Match(document) {
+			return rule.Action == actionKeep
+		}
+	}
+	return true
+}
+
+// Sinks lists the sinks of the event, nil for every sink.
+func (r *Rules) Sinks(document map[string]interface{}) []string {
+	for _, rule := range r.rules {
+		if rule.Action == actionRoute && rule.Expression.Match(document) {
+			return rule.Sinks
+		}
+	}
+	return nil
+}
+
+func (r *Rules) has(actions ...string) bool {
+	for _, rule := range r.rules {
+		for _, action := range actions {
+			if rule.Action == action {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Apply returns the events to push, the events themselves without drop or
+// keep rules.
+func (r *Rules) Apply(items []json.RawMessage) []json.RawMessage {
+	if !r.has(actionDrop, actionKeep) {
+		return items
+	}
+	var kept []json.RawMessage
+	for _, item := range items {
+		if r.Keep(event.Decode(item)) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// Routes lists the sinks named by the route rules.
+func (r *Rules) Routes() []string {
+	var sinks []string
+	for _, rule := range r.rules {
+		for _, sink := range rule.Sinks {
+			if !settings.Contains(sinks, sink) {
+				sinks = append(sinks, sink)
+			}
+		}
+	}
+	return sinks
+}
+
+// Mark adds the sinks the route rules choose for every raw event to the
+// event at the same index of staged, the raw events after the stages
+// changing their fields. Events going to every sink are not changed.
+func (r *Rules) Mark(raw []json.RawMessage, staged []json.RawMessage) []json.RawMessage {
+	if !r.has(actionRoute) {
+		return staged
+	}
+	marked := make([]json.RawMessage, len(staged))
+	for index, item := range staged {
+		marked[index] = item
+		sinks := r.Sinks(event.Decode(raw[index]))
+		document := event.Decode(item)
+		if sinks == nil || document == nil {
+			continue
+		}
+		document[RouteField] = sinks
+		if encoded, err := encode(document); err == nil {
+			marked[index] = encoded
+		}
+	}
+	return marked
+}
+
+// Route returns the events marked for the sink and the events without
+// mark, removing the mark. Events without mark are returned as they are,
+// and without any marked event so are the items.
+func Route(items []json.RawMessage, sink string) []json.RawMessage {
+	if !anyMarked(items) {
+		return items
+	}
+	var routed []json.RawMessage
+	for _, item := range items {
+		if !bytes.Contains(item, routeKey) {
+			routed = append(routed, item)
+			continue
+		}
+		document := event.Decode(item)
+		sinks, ok := document[RouteField].([]interface{})
+		if !ok {
+			routed = append(routed, item)
+			continue
+		}
+		for _, marked := range sinks {
+			if marked == sink {
+				delete(document, RouteField)
+				encoded, _ := encode(document)
+				routed = append(routed, encoded)
+				break
+			}
+		}
+	}
+	return routed
+}
+
+// routeKey is RouteField as key, its bytes are looked for before decoding
+// an event.
+var routeKey = []byte(`"` + RouteField + `"`)
+
+func anyMarked(items []json.RawMessage) bool {
+	for _, item := range items {
+		if bytes.Contains(item, routeKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// encode is json.Marshal without escaping <, > and & for HTML, so the
+// fields of a marked event keep their text.
+func encode(document map[string]interface{}) (json.RawMessage, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(document); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+// Explain describes the decision of the rules on the event, like
+// "keep siem,platform" or "drop".
+func (r *Rules) Explain(item json.RawMessage) string {
+	document := event.Decode(item)
+	if !r.Keep(document) {
+		return actionDrop
+	}
+	sinks := r.Sinks(document)
+	if sinks == nil {
+		return actionKeep + " *"
+	}
+	return actionKeep + " " + strings.Join(sinks, ",")
+}
@@ -0,0 +1,120 @@
+package filter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	delivered  = json.RawMessage(`{"id":"1","event":"delivered","tags":["invoice"]}`)
+	opened     = json.RawMessage(`{"id":"2","event":"opened"}`)
+	permanent  = json.RawMessage(`{"id":"3","event":"failed","severity":"permanent","tags":["invoice"]}`)
+	complained = json.RawMessage(`{"id":"4","event":"complained"}`)
+	events     = []json.RawMessage{delivered, opened, permanent, complained}
+)
+
+func parse(t *testing.T, text string) *Rules {
+	rules, err := Parse(text)
+	assert.Nil(t, err)
+	return rules
+}
+
+func TestFirstMatchingRuleDecides(t *testing.T) {
+	rules := parse(t, `
+		# only permanent failures of invoices, and complaints
+		keep event == "failed" and severity == "permanent" and tags contains "invoice"
+		keep event == "complained"
+		drop true
+	`)
+
+	assert.Equal(t, []json.RawMessage{permanent, complained}, rules.Apply(events))
+}
+
+func TestEventsWithoutMatchingRuleKept(t *testing.T) {
+	rules := parse(t, `drop event == "opened"; route siem event == "complained"`)
+
+	assert.Equal(t, []json.RawMessage{delivered, permanent, complained}, rules.Apply(events))
+}
+
+func TestEventsRoutedToSinks(t *testing.T) {
+	rules := parse(t, `
+		route siem, audit event in ["complained", "failed"]
+		route platform true
+	`)
+	marked := rules.Mark(events, events)
+
+	sortedPermanent := json.RawMessage(`{"event":"failed","id":"3","severity":"permanent","tags":["invoice"]}`)
+	sortedComplained := json.RawMessage(`{"event":"complained","id":"4"}`)
+	sortedDelivered := json.RawMessage(`{"event":"delivered","id":"1","tags":["invoice"]}`)
+	sortedOpened := json.RawMessage(`{"event":"opened","id":"2"}`)
+	assert.Equal(t, []json.RawMessage{sortedPermanent, sortedComplained}, Route(marked, "siem"))
+	assert.Equal(t, []json.RawMessage{sortedPermanent, sortedComplained}, Route(marked, "audit"))
+	assert.Equal(t, []json.RawMessage{sortedDelivered, sortedOpened}, Route(marked, "platform"))
+	assert.Equal(t, []string{"siem", "audit", "platform"}, rules.Routes())
+}
+
+func TestUnroutedEventsGoToEverySink(t *testing.T) {
+	rules := parse(t, `route siem event == "complained"`)
+	marked := rules.Mark(events, events)
+
+	assert.Equal(t, []json.RawMessage{delivered, opened, permanent}, Route(marked, "platform"))
+	assert.Equal(t, []json.RawMessage{delivered, opened, permanent, json.RawMessage(`{"event":"complained","id":"4"}`)}, Route(marked, "siem"))
+}
+
+func TestRoutesDecidedOnRawEvents(t *testing.T) {
+	rules := parse(t, `route siem event == "complained"`)
+	staged := []json.RawMessage{json.RawMessage(`{"id":"4","type":"complained"}`)}
+
+	marked := rules.Mark([]json.RawMessage{complained}, staged)
+
+	assert.Empty(t, Route(marked, "platform"))
+	assert.Equal(t, staged, Route(marked, "siem"))
+}
+
+func TestRoutingKeepsTextOfEvents(t *testing.T) {
+	rules := parse(t, `route siem event == "complained"`)
+	unmarked := json.RawMessage(`{"id":"5","subject":"<b>Tom & Jerry</b>","event":"delivered"}`)
+	marked := rules.Mark(
+		[]json.RawMessage{unmarked, json.RawMessage(`{"event":"complained","subject":"a < b & c"}`)},
+		[]json.RawMessage{unmarked, json.RawMessage(`{"event":"complained","subject":"a < b & c"}`)},
+	)
+
+	assert.Equal(t, []json.RawMessage{unmarked}, Route(marked, "platform"))
+	assert.Equal(t, []json.RawMessage{unmarked, json.RawMessage(`{"event":"complained","subject":"a < b & c"}`)}, Route(marked, "siem"))
+	assert.Equal(t, []json.RawMessage{unmarked}, Route([]json.RawMessage{unmarked}, "siem"))
+}
+
+func TestNoRulesKeepItems(t *testing.T) {
+	rules := parse(t, "")
+
+	assert.Equal(t, events, rules.Apply(events))
+	assert.Equal(t, events, rules.Mark(events, events))
+	assert.Equal(t, events, Route(events, "siem"))
+}
+
+func TestDecisionsExplained(t *testing.T) {
+	rules := parse(t, `drop event == "opened"; route siem event == "complained"`)
+
+	assert.Equal(t, "keep *", rules.Explain(delivered))
+	assert.Equal(t, "drop", rules.Explain(opened))
+	assert.Equal(t, "keep siem", rules.Explain(complained))
+}
+
+func TestInvalidRulesFail(t *testing.T) {
+	_, err := Parse("drop event == \"opened\"\nforward siem true")
+	assert.EqualError(t, err, "Invalid rule 2: Unknown action forward")
+
+	_, err = Parse(`route "siem" true`)
+	assert.EqualError(t, err, `Invalid rule 1: Expected a sink name instead of "siem"`)
+
+	assert.PanicsWithValue(t, "Failed to parse filter rules. Invalid rule 1: Unknown action send", func() {
+		FromConfig(func(key string) string {
+			if key == "FILTER_RULES" {
+				return "send true"
+			}
+			return ""
+		})
+	})
+}
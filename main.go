@@ -1,26 +1,49 @@
 package main
 
 import (
+	"encoding/json"
+	"expvar"
 	"fmt"
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/joho/godotenv"
+	"io"
 	"log"
+	"matchwork/mailgun-log-fetcher/batch"
+	"matchwork/mailgun-log-fetcher/enrich"
 	"matchwork/mailgun-log-fetcher/fetcher"
+	"matchwork/mailgun-log-fetcher/filter"
 	pusherPack "matchwork/mailgun-log-fetcher/pusher"
+	"matchwork/mailgun-log-fetcher/redact"
+	"matchwork/mailgun-log-fetcher/spool"
+	"matchwork/mailgun-log-fetcher/transform"
+	"matchwork/mailgun-log-fetcher/webhook"
+	"net/http"
 	"os"
 	"strconv"
 	"time"
 )
 
 var fetchAction = fetcher.Fetch
-var pusherCreator = pusherPack.New
+var pusherCreator = pusherPack.FromEnv
 
-var clock = &RealClock{}
+var clock fetcher.ClockInterface = &RealClock{}
 var now = clock.Now().Unix()
 
 const mailgunEuDomain = "https://api.eu.mailgun.net/v3/"
 const mailgunUsDomain = "https://api.mailgun.net/v3/"
 
+const defaultSpoolSegmentMaxBytes = 16 * 1024 * 1024
+const defaultSpoolMaxBytes = 1024 * 1024 * 1024
+
+const defaultWebhookListenAddress = ":8443"
+const defaultWebhookPath = "/webhook"
+const defaultWebhookMaxAgeSeconds = 300
+const defaultWebhookBatchSize = 100
+const defaultWebhookBatchWaitMilliseconds = 1000
+const defaultHybridSeenTtlSeconds = 24 * 60 * 60
+
+var hybridGapsFilled = expvar.NewInt("hybrid_gaps_filled")
+
 type RealClock struct {
 }
 
@@ -48,15 +71,214 @@ func getMailgunDomain() string {
 	panic(fmt.Sprintf("no url for current region setting: %s", os.Getenv("MAILGUN_REGION")))
 }
 
-func main() {
+func envInt64(name string, defaultValue int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// drain feeds the spooled pages to the sink, retrying a page until the sink
+// accepts it. Corrupt records are quarantined by the spool, a failed read
+// is tried again.
+func drain(queue *spool.Spool) {
+	for true {
+		items, ok, err := queue.Peek()
+		if err != nil {
+			log.Printf("Failed to read spool, trying again. %s", err)
+			clock.Sleep(10 * time.Second)
+			continue
+		}
+		if !ok {
+			clock.Sleep(time.Second)
+			continue
+		}
+		if err := pusherPack.TryPush(pusherCreator, items); err != nil {
+			log.Printf("Push failed, keeping the page in spool. %s", err)
+			clock.Sleep(10 * time.Second)
+			continue
+		}
+		if err := queue.Ack(); err != nil {
+			panic(fmt.Sprintf("Failed to update spool. %s", err))
+		}
+	}
+}
+
+// deliverer returns how pages reach the sink: through the spool when
+// SPOOL_DIR is set, with push otherwise.
+func deliverer(push func(items []json.RawMessage) error) func(items []json.RawMessage) error {
+	spoolDir := os.Getenv("SPOOL_DIR")
+	if spoolDir == "" {
+		return push
+	}
+	queue, err := spool.Open(spoolDir, envInt64("SPOOL_SEGMENT_MAX_BYTES", defaultSpoolSegmentMaxBytes), envInt64("SPOOL_MAX_BYTES", defaultSpoolMaxBytes))
+	if err != nil {
+		panic(fmt.Sprintf("Failed to open spool. %s", err))
+	}
+	go drain(queue)
+	return queue.Write
+}
+
+// staged returns deliver with the configured stages applied to every event
+// before it. Filtering, enrichment and redaction work on the fields of
+// Mailgun, so they come before the transform, and enrichment can look up
+// recipients before they are redacted. Routes are decided on the events
+// before the stages and marked on them after.
+func staged(deliver func(items []json.RawMessage) error) func(items []json.RawMessage) error {
+	rules := filter.FromConfig(os.Getenv)
+	pusherPack.CheckRoutes(os.Getenv)
+	enricher := enrich.New(os.Getenv)
+	redactor := redact.New(os.Getenv)
+	transformer := transform.New(os.Getenv)
+	return func(items []json.RawMessage) error {
+		kept := rules.Apply(items)
+		return deliver(rules.Mark(kept, transformer.Apply(redactor.Apply(enricher.Apply(kept)))))
+	}
+}
+
+// testFilter prints the decision of the filter rules on every event of the
+// files, or of the standard input without files. Events are read as JSON
+// objects, arrays of them, or pages of the events API.
+func testFilter(files []string, out io.Writer) error {
+	rules := filter.FromConfig(os.Getenv)
+	var readers []io.Reader
+	for _, file := range files {
+		content, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		readers = append(readers, content)
+	}
+	if len(readers) == 0 {
+		readers = append(readers, os.Stdin)
+	}
+
+	decoder := json.NewDecoder(io.MultiReader(readers...))
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var items []json.RawMessage
+		var page fetcher.Response
+		if json.Unmarshal(value, &items) != nil {
+			if json.Unmarshal(value, &page) == nil && page.Items != nil {
+				items = page.Items
+			} else {
+				items = []json.RawMessage{value}
+			}
+		}
+		for _, item := range items {
+			fmt.Fprintf(out, "%s\t%s\n", rules.Explain(item), item)
+		}
+	}
+}
+
+func tryPush(items []json.RawMessage) error {
+	return pusherPack.TryPush(pusherCreator, items)
+}
+
+// listen serves the Mailgun webhooks, delivering their events in batches.
+func listen(deliver func(items []json.RawMessage) error) {
+	signingKey := os.Getenv("MAILGUN_WEBHOOK_SIGNING_KEY")
+	if signingKey == "" {
+		panic("MAILGUN_WEBHOOK_SIGNING_KEY is required to serve webhooks")
+	}
+	maxAge := time.Duration(envInt64("WEBHOOK_MAX_AGE_SECONDS", defaultWebhookMaxAgeSeconds)) * time.Second
+
+	path := os.Getenv("WEBHOOK_PATH")
+	if path == "" {
+		path = defaultWebhookPath
+	}
+	address := os.Getenv("WEBHOOK_LISTEN_ADDRESS")
+	if address == "" {
+		address = defaultWebhookListenAddress
+	}
+	batcher := batch.New(
+		int(envInt64("WEBHOOK_BATCH_SIZE", defaultWebhookBatchSize)),
+		time.Duration(envInt64("WEBHOOK_BATCH_WAIT_MILLISECONDS", defaultWebhookBatchWaitMilliseconds))*time.Millisecond,
+		deliver,
+	)
+	mux := http.NewServeMux()
+	mux.Handle(path, webhook.NewHandler(signingKey, maxAge, batcher.Push))
+
+	var err error
+	certFile, keyFile := os.Getenv("WEBHOOK_TLS_CERT_FILE"), os.Getenv("WEBHOOK_TLS_KEY_FILE")
+	if certFile != "" || keyFile != "" {
+		err = http.ListenAndServeTLS(address, certFile, keyFile, mux)
+	} else {
+		log.Println("WEBHOOK_TLS_CERT_FILE is not set, serving webhooks without TLS.")
+		err = http.ListenAndServe(address, mux)
+	}
+	panic(fmt.Sprintf("Failed to serve webhooks. %s", err))
+}
+
+// poll fetches the pages of the events API from the start of the process,
+// fetching a page again until it is delivered.
+func poll(deliver func(items []json.RawMessage) error) {
 	var response fetcher.Response
 	url := fmt.Sprintf("%s%s/events?begin=%s&ascending=yes", getMailgunDomain(), os.Getenv("MAIL_DOMAIN"), strconv.FormatInt(now, 10))
 	var client = retryablehttp.NewClient()
 
 	for true {
 		response = fetchAction(url, client, clock)
-		pusher := pusherCreator()
-		pusher.Push(response.Items)
+		if err := deliver(response.Items); err != nil {
+			log.Printf("Delivery failed, fetching the page again. %s", err)
+			clock.Sleep(10 * time.Second)
+			continue
+		}
 		url = response.Paging.Next
 	}
 }
+
+// claiming delivers only the events not delivered by the other path of the
+// hybrid mode, adding their number to gaps when it is set.
+func claiming(seen *webhook.Seen, deliver func(items []json.RawMessage) error, gaps *expvar.Int) func(items []json.RawMessage) error {
+	return func(items []json.RawMessage) error {
+		claimed := seen.Claim(items)
+		if len(claimed) == 0 {
+			return nil
+		}
+		if err := deliver(claimed); err != nil {
+			seen.Release(claimed)
+			return err
+		}
+		if gaps != nil {
+			gaps.Add(int64(len(claimed)))
+		}
+		return nil
+	}
+}
+
+func main() {
+	if metricsAddress := os.Getenv("METRICS_LISTEN_ADDRESS"); metricsAddress != "" {
+		go func() {
+			panic(fmt.Sprintf("Failed to serve metrics. %s", http.ListenAndServe(metricsAddress, nil)))
+		}()
+	}
+
+	mode := ""
+	if len(os.Args) > 1 {
+		mode = os.Args[1]
+	}
+	switch mode {
+	case "filter-test":
+		if err := testFilter(os.Args[2:], os.Stdout); err != nil {
+			panic(fmt.Sprintf("Failed to read events. %s", err))
+		}
+	case "serve":
+		listen(staged(deliverer(tryPush)))
+	case "hybrid":
+		seen := webhook.NewSeen(time.Duration(envInt64("HYBRID_SEEN_TTL_SECONDS", defaultHybridSeenTtlSeconds)) * time.Second)
+		deliver := staged(deliverer(tryPush))
+		go listen(claiming(seen, deliver, nil))
+		poll(claiming(seen, deliver, hybridGapsFilled))
+	default:
+		poll(staged(deliverer(tryPush)))
+	}
+}
@@ -2,14 +2,19 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"expvar"
 	"github.com/stretchr/testify/mock"
 	"matchwork/mailgun-log-fetcher/fetcher"
 	"matchwork/mailgun-log-fetcher/pusher"
+	"matchwork/mailgun-log-fetcher/spool"
 	"matchwork/mailgun-log-fetcher/utils"
+	"matchwork/mailgun-log-fetcher/webhook"
 	"os"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 type PushMock struct {
@@ -17,8 +22,17 @@ type PushMock struct {
 }
 
 func (m *PushMock) Push(items []json.RawMessage) error {
-	m.Called(items)
-	return nil
+	args := m.Called(items)
+	return args.Error(0)
+}
+
+type FakeClock struct {
+	RealClock
+	mock.Mock
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	c.Called(d)
 }
 
 type Mocks struct {
@@ -126,4 +140,225 @@ func TestInvalidRegionFailed(t *testing.T) {
 
 	main()
 	os.Setenv("MAILGUN_DOMAIN", originalVar)
-}
\ No newline at end of file
+}
+
+func TestFailedPushKeepsCursor(t *testing.T) {
+	utils.InitTestEnv()
+	t.Setenv("MAILGUN_REGION", "eu")
+	firstUrl := mailgunEuDomain + os.Getenv("MAIL_DOMAIN") +"/events?begin="+ strconv.FormatInt(now, 10) +"&ascending=yes"
+	response := fetcher.Response{
+		Items:  nil,
+		Paging: fetcher.Paging{
+			Next: "next url",
+		},
+	}
+
+	fetchFunction := new(Mocks)
+	fetchFunction.
+		On("fetch", firstUrl, mock.Anything, mock.Anything ).Return(response).Twice()
+
+	pushMock := new(PushMock)
+	pushMock.
+		On("Push", response.Items).Return(errors.New("sink down")).Once().
+		On("Push", response.Items).Return(nil).Once()
+
+	fakeClock := new(FakeClock)
+	fakeClock.On("Sleep", 10*time.Second).Once()
+
+	fetchAction = fetchFunction.fetch
+	pusherCreator = func () pusher.PusherInterface {
+		return pushMock
+	}
+	clock = fakeClock
+
+	defer func() {
+		f := recover()
+		if !strings.Contains(f.(string), "Break infinite") {
+			t.Errorf("another panic expected. %s", f)
+		}
+		clock = &RealClock{}
+		fetchFunction.AssertExpectations(t)
+		pushMock.AssertExpectations(t)
+		fakeClock.AssertExpectations(t)
+	}()
+
+	main()
+}
+
+func TestFailedSinkConnectKeepsCursor(t *testing.T) {
+	utils.InitTestEnv()
+	t.Setenv("MAILGUN_REGION", "eu")
+	firstUrl := mailgunEuDomain + os.Getenv("MAIL_DOMAIN") +"/events?begin="+ strconv.FormatInt(now, 10) +"&ascending=yes"
+	response := fetcher.Response{
+		Items:  nil,
+		Paging: fetcher.Paging{
+			Next: "next url",
+		},
+	}
+
+	fetchFunction := new(Mocks)
+	fetchFunction.
+		On("fetch", firstUrl, mock.Anything, mock.Anything ).Return(response).Twice()
+
+	pushMock := new(PushMock)
+	pushMock.On("Push", response.Items).Return(nil).Once()
+
+	fakeClock := new(FakeClock)
+	fakeClock.On("Sleep", 10*time.Second).Once()
+
+	created := 0
+	fetchAction = fetchFunction.fetch
+	pusherCreator = func () pusher.PusherInterface {
+		created++
+		if created == 1 {
+			panic("Failed to connect to remote host.")
+		}
+		return pushMock
+	}
+	clock = fakeClock
+
+	defer func() {
+		f := recover()
+		if !strings.Contains(f.(string), "Break infinite") {
+			t.Errorf("another panic expected. %s", f)
+		}
+		clock = &RealClock{}
+		fetchFunction.AssertExpectations(t)
+		pushMock.AssertExpectations(t)
+		fakeClock.AssertExpectations(t)
+	}()
+
+	main()
+}
+
+func TestSpooledPageDrainedAfterFailedPush(t *testing.T) {
+	queue, _ := spool.Open(t.TempDir(), 1024, 1024)
+	items := []json.RawMessage{json.RawMessage(`{"id":"1"}`)}
+	queue.Write(items)
+
+	pushMock := new(PushMock)
+	pushMock.
+		On("Push", items).Return(errors.New("sink down")).Once().
+		On("Push", items).Return(nil).Once()
+	pusherCreator = func () pusher.PusherInterface {
+		return pushMock
+	}
+
+	fakeClock := new(FakeClock)
+	fakeClock.
+		On("Sleep", 10*time.Second).Once().
+		On("Sleep", time.Second).Run(func(args mock.Arguments) {
+			panic("Break infinite loop, done")
+		})
+	clock = fakeClock
+
+	defer func() {
+		f := recover()
+		if !strings.Contains(f.(string), "Break infinite") {
+			t.Errorf("another panic expected. %s", f)
+		}
+		clock = &RealClock{}
+		pushMock.AssertExpectations(t)
+		if _, ok, _ := queue.Peek(); ok {
+			t.Errorf("Empty spool expected.")
+		}
+	}()
+
+	drain(queue)
+}
+
+func TestHybridPollPushesOnlyGaps(t *testing.T) {
+	seen := webhook.NewSeen(time.Hour)
+	received := json.RawMessage(`{"id":"1"}`)
+	missed := json.RawMessage(`{"id":"2"}`)
+	var delivered [][]json.RawMessage
+	deliver := func(items []json.RawMessage) error {
+		delivered = append(delivered, items)
+		return nil
+	}
+	gaps := new(expvar.Int)
+
+	claiming(seen, deliver, nil)([]json.RawMessage{received})
+	err := claiming(seen, deliver, gaps)([]json.RawMessage{received, missed})
+
+	if err != nil {
+		t.Errorf("No error expected. %s", err)
+	}
+	if len(delivered) != 2 || len(delivered[1]) != 1 || string(delivered[1][0]) != string(missed) {
+		t.Errorf("Only the missed event expected to be delivered by polling. %s", delivered)
+	}
+	if gaps.Value() != 1 {
+		t.Errorf("One gap expected to be filled, got %d.", gaps.Value())
+	}
+}
+
+func TestStagesTransformEvents(t *testing.T) {
+	t.Setenv("TRANSFORM_DROP", "message")
+	t.Setenv("TRANSFORM_RENAME", "recipient=to")
+	t.Setenv("REDACT_MASK", "recipient")
+	var delivered []json.RawMessage
+
+	staged(func(items []json.RawMessage) error {
+		delivered = items
+		return nil
+	})([]json.RawMessage{json.RawMessage(`{"id":"1","recipient":"jane@example.com","message":{"size":10}}`)})
+
+	if len(delivered) != 1 || string(delivered[0]) != `{"id":"1","to":"j***@example.com"}` {
+		t.Errorf("Redacted and transformed event expected. %s", delivered)
+	}
+}
+
+func TestStagesEnrichBeforeRedaction(t *testing.T) {
+	file := t.TempDir() + "/accounts.csv"
+	os.WriteFile(file, []byte("recipient,account\njane@example.com,A-1\n"), 0600)
+	t.Setenv("ENRICH_TABLES", "customer")
+	t.Setenv("CUSTOMER_FILE", file)
+	t.Setenv("CUSTOMER_KEY", "recipient")
+	t.Setenv("REDACT_MASK", "recipient")
+	var delivered []json.RawMessage
+
+	staged(func(items []json.RawMessage) error {
+		delivered = items
+		return nil
+	})([]json.RawMessage{json.RawMessage(`{"id":"1","recipient":"jane@example.com"}`)})
+
+	if len(delivered) != 1 || string(delivered[0]) != `{"customer":{"account":"A-1"},"id":"1","recipient":"j***@example.com"}` {
+		t.Errorf("Enriched and redacted event expected. %s", delivered)
+	}
+}
+
+func TestFilterRulesTestedOnEvents(t *testing.T) {
+	t.Setenv("FILTER_RULES", `drop event == "opened"; route siem event == "complained"`)
+	file := t.TempDir() + "/events.json"
+	os.WriteFile(file, []byte(`{"items":[{"event":"opened"},{"event":"complained"}],"paging":{}}
+{"event":"delivered"}
+[{"event":"failed"}]`), 0600)
+	var out strings.Builder
+
+	err := testFilter([]string{file}, &out)
+
+	if err != nil {
+		t.Errorf("No error expected. %s", err)
+	}
+	expected := "drop\t{\"event\":\"opened\"}\nkeep siem\t{\"event\":\"complained\"}\nkeep *\t{\"event\":\"delivered\"}\nkeep *\t{\"event\":\"failed\"}\n"
+	if out.String() != expected {
+		t.Errorf("Decisions expected, got %s", out.String())
+	}
+}
+
+func TestStagesRouteOnRawEvents(t *testing.T) {
+	t.Setenv("SINK", "fanout")
+	t.Setenv("FANOUT_SINKS", "siem,platform")
+	t.Setenv("FILTER_RULES", `route siem event == "complained"`)
+	t.Setenv("TRANSFORM_RENAME", "event=type")
+	var delivered []json.RawMessage
+
+	staged(func(items []json.RawMessage) error {
+		delivered = items
+		return nil
+	})([]json.RawMessage{json.RawMessage(`{"id":"1","event":"complained"}`)})
+
+	if len(delivered) != 1 || string(delivered[0]) != `{"_route":["siem"],"id":"1","type":"complained"}` {
+		t.Errorf("Route decided on the raw event expected. %s", delivered)
+	}
+}
@@ -0,0 +1,154 @@
+package pusher
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"matchwork/mailgun-log-fetcher/event"
+	"strconv"
+	"time"
+)
+
+const defaultIndexPrefix = "mailgun-"
+const defaultIndexDateLayout = "2006.01.02"
+
+// elasticsearchRejectedEvents counts the events refused for good, like for a
+// mapping error.
+var elasticsearchRejectedEvents = expvar.NewInt("elasticsearch_rejected_events")
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// ElasticsearchPusher indexes events with the _bulk API of Elasticsearch or
+// OpenSearch into daily indices by the event time. The Mailgun event ID is
+// the document ID, so a page pushed again overwrites the same documents.
+type ElasticsearchPusher struct {
+	endpoint        *httpEndpoint
+	indexPrefix     string
+	indexDateLayout string
+	batchSize       int
+	retryMax        int
+}
+
+func init() {
+	Register("elasticsearch", NewElasticsearch)
+}
+
+func NewElasticsearch(config Config) PusherInterface {
+	endpoint := newHttpEndpoint(config, "ES_")
+	if apiKey := config("ES_API_KEY"); apiKey != "" {
+		endpoint.headers.Set("Authorization", "ApiKey "+apiKey)
+	}
+
+	pusher := &ElasticsearchPusher{
+		endpoint:        endpoint,
+		indexPrefix:     config("ES_INDEX_PREFIX"),
+		indexDateLayout: config("ES_INDEX_DATE_FORMAT"),
+		batchSize:       batchSize(config("ES_BATCH_SIZE")),
+		retryMax:        defaultRetryMax,
+	}
+	if pusher.indexPrefix == "" {
+		pusher.indexPrefix = defaultIndexPrefix
+	}
+	if pusher.indexDateLayout == "" {
+		pusher.indexDateLayout = defaultIndexDateLayout
+	}
+	if retryMax, err := strconv.Atoi(config("ES_ITEM_RETRY_MAX")); err == nil {
+		pusher.retryMax = retryMax
+	}
+	return pusher
+}
+
+func (p *ElasticsearchPusher) Push(items []json.RawMessage) error {
+	for _, batch := range batches(items, p.batchSize) {
+		if err := p.pushBatch(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close aborts a push in progress.
+func (p *ElasticsearchPusher) Close() error {
+	p.endpoint.close()
+	return nil
+}
+
+// pushBatch sends the batch again and again with the items failed with a
+// temporary error only, until all of them are indexed.
+func (p *ElasticsearchPusher) pushBatch(batch []json.RawMessage) error {
+	for attempt := 0; ; attempt++ {
+		response, err := p.bulk(batch)
+		if err != nil {
+			return err
+		}
+
+		failed := p.retryableItems(batch, response)
+		if len(failed) == 0 {
+			return nil
+		}
+		if attempt >= p.retryMax {
+			return fmt.Errorf("Indexing of %d events failed after %d retries.", len(failed), p.retryMax)
+		}
+		sleep(time.Duration(attempt+1) * time.Second)
+		batch = failed
+	}
+}
+
+func (p *ElasticsearchPusher) bulk(batch []json.RawMessage) (*bulkResponse, error) {
+	var body []byte
+	for _, item := range batch {
+		action, _ := json.Marshal(map[string]interface{}{"index": p.action(event.Parse(item))})
+		body = append(append(append(append(body, action...), '\n'), item...), '\n')
+	}
+
+	responseBody, err := p.endpoint.post("/_bulk", body, "application/x-ndjson")
+	if err != nil {
+		return nil, err
+	}
+	var response bulkResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return nil, fmt.Errorf("Invalid bulk response. %s", err)
+	}
+	if response.Errors && len(response.Items) != len(batch) {
+		return nil, fmt.Errorf("Bulk response has %d items for %d events.", len(response.Items), len(batch))
+	}
+	return &response, nil
+}
+
+func (p *ElasticsearchPusher) action(e event.Event) map[string]string {
+	action := map[string]string{"_index": p.indexPrefix + e.Time().Format(p.indexDateLayout)}
+	if e.ID != "" {
+		action["_id"] = e.ID
+	}
+	return action
+}
+
+// retryableItems returns the items failed with 429 or 5xx. Items failed with
+// another status are logged and dropped, they would fail again.
+func (p *ElasticsearchPusher) retryableItems(batch []json.RawMessage, response *bulkResponse) []json.RawMessage {
+	if !response.Errors {
+		return nil
+	}
+
+	var retryable []json.RawMessage
+	for index, result := range response.Items {
+		for _, action := range result {
+			switch {
+			case action.Status < 300:
+			case action.Status == 429 || action.Status >= 500:
+				retryable = append(retryable, batch[index])
+			default:
+				log.Printf("Elasticsearch rejected event %s. %s", event.Parse(batch[index]).ID, action.Error)
+				elasticsearchRejectedEvents.Add(1)
+			}
+		}
+	}
+	return retryable
+}
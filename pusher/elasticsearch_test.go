@@ -0,0 +1,116 @@
+package pusher
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeElasticsearch answers the bulk requests with the statuses in order,
+// 201 when they run out.
+type fakeElasticsearch struct {
+	sync.Mutex
+	statuses []int
+	actions  []string
+	bodies   []string
+}
+
+func (es *fakeElasticsearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	es.Lock()
+	defer es.Unlock()
+
+	var items []string
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 65536), 1024*1024)
+	for scanner.Scan() {
+		es.actions = append(es.actions, scanner.Text())
+		scanner.Scan()
+		es.bodies = append(es.bodies, scanner.Text())
+
+		status := 201
+		if len(es.statuses) > 0 {
+			status, es.statuses = es.statuses[0], es.statuses[1:]
+		}
+		items = append(items, fmt.Sprintf(`{"index":{"status":%d,"error":{"type":"status_%d"}}}`, status, status))
+	}
+	fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%s]}`, strings.Join(items, ","))
+}
+
+var elasticsearchItems = []json.RawMessage{
+	json.RawMessage(`{"id":"ZYqv-femT-eZdNugraadzQ","timestamp":1636532241.823582}`),
+	json.RawMessage(`{"id":"LMbm5AS1S8SQDHvUE-VGug","timestamp":1636646172.343453}`),
+	json.RawMessage(`{"id":"EEbmpTfvS2amOYYAK8JsIA","timestamp":1636646272.123123}`),
+}
+
+func TestEventsIndexedByDateWithEventId(t *testing.T) {
+	es := &fakeElasticsearch{}
+	server := httptest.NewServer(es)
+	defer server.Close()
+
+	err := NewElasticsearch(configOf(map[string]string{"ES_URL": server.URL, "ES_API_KEY": "key"})).Push(elasticsearchItems[:2])
+
+	assertNoErrors(t, err)
+	expected := []string{
+		`{"index":{"_id":"ZYqv-femT-eZdNugraadzQ","_index":"mailgun-2021.11.10"}}`,
+		`{"index":{"_id":"LMbm5AS1S8SQDHvUE-VGug","_index":"mailgun-2021.11.11"}}`,
+	}
+	if strings.Join(es.actions, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Failed asserting actions %v", es.actions)
+	}
+	if es.bodies[0] != string(elasticsearchItems[0]) {
+		t.Errorf("Event as document expected.")
+	}
+}
+
+func TestOnlyTemporarilyFailedItemsRetried(t *testing.T) {
+	noSleep(t)
+	es := &fakeElasticsearch{statuses: []int{201, 429, 503}}
+	server := httptest.NewServer(es)
+	defer server.Close()
+
+	err := NewElasticsearch(configOf(map[string]string{"ES_URL": server.URL})).Push(elasticsearchItems)
+
+	assertNoErrors(t, err)
+	if len(es.bodies) != 5 || es.bodies[3] != string(elasticsearchItems[1]) || es.bodies[4] != string(elasticsearchItems[2]) {
+		t.Errorf("Retry of second and third event expected. %v", es.bodies)
+	}
+}
+
+func TestPermanentlyFailedItemDropped(t *testing.T) {
+	noSleep(t)
+	es := &fakeElasticsearch{statuses: []int{400, 201, 201}}
+	server := httptest.NewServer(es)
+	defer server.Close()
+	rejectedBefore := elasticsearchRejectedEvents.Value()
+
+	err := NewElasticsearch(configOf(map[string]string{"ES_URL": server.URL, "ES_INDEX_PREFIX": "events-", "ES_INDEX_DATE_FORMAT": "2006.01"})).Push(elasticsearchItems)
+
+	assertNoErrors(t, err)
+	if len(es.bodies) != 3 {
+		t.Errorf("No retry expected for mapping error.")
+	}
+	if !strings.Contains(es.actions[0], `"_index":"events-2021.11"`) {
+		t.Errorf("Monthly index expected. %s", es.actions[0])
+	}
+	if elasticsearchRejectedEvents.Value() != rejectedBefore+1 {
+		t.Errorf("Rejected event expected to be counted.")
+	}
+}
+
+func TestExhaustedItemRetriesFailPush(t *testing.T) {
+	noSleep(t)
+	es := &fakeElasticsearch{statuses: []int{503, 503, 503}}
+	server := httptest.NewServer(es)
+	defer server.Close()
+
+	err := NewElasticsearch(configOf(map[string]string{"ES_URL": server.URL, "ES_ITEM_RETRY_MAX": "2"})).Push(elasticsearchItems[:1])
+
+	if err == nil || !strings.Contains(err.Error(), "after 2 retries") {
+		t.Errorf("Retry error expected. %s", err)
+	}
+}
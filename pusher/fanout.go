@@ -0,0 +1,321 @@
+package pusher
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"matchwork/mailgun-log-fetcher/filter"
+	"matchwork/mailgun-log-fetcher/settings"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const policyRequired = "required"
+const policyBestEffort = "best-effort"
+const defaultRetryMax = 3
+const maxPendingPages = 1024
+
+var sleep = time.Sleep
+
+// fanOutFailures counts the failed pushes of best-effort sinks by sink name.
+var fanOutFailures = expvar.NewMap("fanout_best_effort_failures")
+
+type fanOutSink struct {
+	name     string
+	required bool
+	retryMax int
+	timeout  time.Duration
+	create   func() PusherInterface
+}
+
+// pageLedger remembers the sinks which took a page while another required
+// sink failed it, so the retry of the page only goes to the failed sinks.
+// The oldest pages are forgotten after maxPendingPages.
+type pageLedger struct {
+	mutex sync.Mutex
+	pages []string
+	sinks map[string]map[string]bool
+}
+
+// pushedPages is shared by the fan-outs, which are created for every push.
+var pushedPages = &pageLedger{sinks: map[string]map[string]bool{}}
+
+func pageKey(items []json.RawMessage) string {
+	hash := sha256.New()
+	for _, item := range items {
+		hash.Write(item)
+		hash.Write([]byte{'\n'})
+	}
+	return string(hash.Sum(nil))
+}
+
+func (l *pageLedger) pushed(page string, sink string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.sinks[page][sink]
+}
+
+func (l *pageLedger) add(page string, sinks []string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.sinks[page]; !ok {
+		l.sinks[page] = map[string]bool{}
+		l.pages = append(l.pages, page)
+		if len(l.pages) > maxPendingPages {
+			delete(l.sinks, l.pages[0])
+			l.pages = l.pages[1:]
+		}
+	}
+	for _, sink := range sinks {
+		l.sinks[page][sink] = true
+	}
+}
+
+func (l *pageLedger) forget(page string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.sinks[page]; !ok {
+		return
+	}
+	delete(l.sinks, page)
+	for index, pending := range l.pages {
+		if pending == page {
+			l.pages = append(l.pages[:index], l.pages[index+1:]...)
+			break
+		}
+	}
+}
+
+type sinkResult struct {
+	sink *fanOutSink
+	err  error
+}
+
+// FanOut pushes every page to several sinks concurrently. A failure of a
+// required sink fails the whole push, so the cursor is not advanced, and the
+// retry of the page goes to the sinks which did not take it. A failure of a
+// best-effort sink is only logged and counted. The sinks route rules of the
+// filter marked on an event get it, an event without mark goes to every sink.
+type FanOut struct {
+	sinks []*fanOutSink
+}
+
+func init() {
+	Register("fanout", NewFanOut)
+}
+
+// NewFanOut creates the sinks listed in FANOUT_SINKS. Each sink reads its
+// settings with its upper cased name as prefix (i.e. SIEM_SINK, SIEM_POLICY,
+// SIEM_REMOTE_LOG_HOST) and falls back to the unprefixed setting.
+func NewFanOut(config Config) PusherInterface {
+	names := fanOutNames(config)
+	if len(names) == 0 {
+		panic("FANOUT_SINKS has no sink.")
+	}
+	checkRouteSinks(filter.FromConfig(config), names)
+
+	fanOut := &FanOut{}
+	for _, name := range names {
+		fanOut.sinks = append(fanOut.sinks, newFanOutSink(name, prefixed(config, name)))
+	}
+	return fanOut
+}
+
+func fanOutNames(config Config) []string {
+	return settings.List(config("FANOUT_SINKS"))
+}
+
+// CheckRoutes fails at startup on route rules without the fan-out sink, or
+// naming a sink which is not in FANOUT_SINKS.
+func CheckRoutes(config Config) {
+	rules := filter.FromConfig(config)
+	if len(rules.Routes()) == 0 {
+		return
+	}
+	if config("SINK") != "fanout" {
+		panic("Route rules need SINK=fanout.")
+	}
+	checkRouteSinks(rules, fanOutNames(config))
+}
+
+func checkRouteSinks(rules *filter.Rules, names []string) {
+	for _, route := range rules.Routes() {
+		if !settings.Contains(names, route) {
+			panic(fmt.Sprintf("Route rules name a sink not in FANOUT_SINKS: %s", route))
+		}
+	}
+}
+
+func newFanOutSink(name string, config Config) *fanOutSink {
+	sinkType := config("SINK")
+	if _, ok := sinks[sinkType]; !ok || sinkType == "fanout" {
+		panic(fmt.Sprintf("Unknown sink type for %s: %s", name, sinkType))
+	}
+
+	policy := config("POLICY")
+	if policy == "" {
+		policy = policyRequired
+	}
+	if policy != policyRequired && policy != policyBestEffort {
+		panic(fmt.Sprintf("Unknown policy for %s: %s", name, policy))
+	}
+
+	retryMax, err := strconv.Atoi(config("RETRY_MAX"))
+	if err != nil {
+		retryMax = defaultRetryMax
+	}
+	timeout, _ := time.ParseDuration(config("TIMEOUT"))
+
+	return &fanOutSink{
+		name:     name,
+		required: policy == policyRequired,
+		retryMax: retryMax,
+		timeout:  timeout,
+		create: func() PusherInterface {
+			return Create(sinkType, config)
+		},
+	}
+}
+
+// prefixed looks up NAME_KEY first and KEY when it is not set.
+func prefixed(config Config, name string) Config {
+	prefix := settings.Prefix(name)
+	return func(key string) string {
+		if value := config(prefix + key); value != "" {
+			return value
+		}
+		if key == "SINK" {
+			return ""
+		}
+		return config(key)
+	}
+}
+
+func (f *FanOut) Push(items []json.RawMessage) error {
+	page := ""
+	if len(items) > 0 {
+		page = pageKey(items)
+	}
+
+	results := make(chan sinkResult, len(f.sinks))
+	for _, sink := range f.sinks {
+		go func(sink *fanOutSink) {
+			if page != "" && pushedPages.pushed(page, sink.name) {
+				results <- sinkResult{sink: sink}
+				return
+			}
+			routed := filter.Route(items, sink.name)
+			if len(routed) == 0 && len(items) > 0 {
+				results <- sinkResult{sink: sink}
+				return
+			}
+			results <- sinkResult{sink: sink, err: sink.push(routed)}
+		}(sink)
+	}
+
+	var failed, pushed []string
+	for range f.sinks {
+		result := <-results
+		if result.err == nil {
+			pushed = append(pushed, result.sink.name)
+			continue
+		}
+		if result.sink.required {
+			failed = append(failed, fmt.Sprintf("%s: %s", result.sink.name, result.err))
+			continue
+		}
+		log.Printf("Best-effort sink %s failed. %s", result.sink.name, result.err)
+		fanOutFailures.Add(result.sink.name, 1)
+	}
+
+	if len(failed) > 0 {
+		if page != "" {
+			pushedPages.add(page, pushed)
+		}
+		return fmt.Errorf("Required sinks failed. %s", strings.Join(failed, "; "))
+	}
+	pushedPages.forget(page)
+	return nil
+}
+
+// sinkPush is the push of a sink with its retries, which can be stopped:
+// the sink in use is closed and no more retries start.
+type sinkPush struct {
+	mutex   sync.Mutex
+	stopped bool
+	pusher  PusherInterface
+}
+
+// use tells whether the pusher can push, a stopped push closes it.
+func (p *sinkPush) use(pusher PusherInterface) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.pusher = pusher
+	if p.stopped {
+		closeSink(pusher)
+	}
+	return !p.stopped
+}
+
+func (p *sinkPush) active() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return !p.stopped
+}
+
+func (p *sinkPush) stop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stopped = true
+	if p.pusher != nil {
+		closeSink(p.pusher)
+	}
+}
+
+func closeSink(pusher PusherInterface) {
+	if closer, ok := pusher.(CloserInterface); ok {
+		closer.Close()
+	}
+}
+
+// push retries the sink on its own, so a failing sink only delays the page
+// by its own retries. A sink with timeout is closed after it.
+func (s *fanOutSink) push(items []json.RawMessage) error {
+	current := &sinkPush{}
+	if s.timeout == 0 {
+		return s.pushWithRetry(items, current)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.pushWithRetry(items, current)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(s.timeout):
+		current.stop()
+		return fmt.Errorf("Timed out after %s", s.timeout)
+	}
+}
+
+func (s *fanOutSink) pushWithRetry(items []json.RawMessage, current *sinkPush) error {
+	create := func() PusherInterface {
+		pusher := s.create()
+		if !current.use(pusher) {
+			panic("Push stopped.")
+		}
+		return pusher
+	}
+
+	err := TryPush(create, items)
+	for attempt := 1; err != nil && attempt <= s.retryMax && current.active(); attempt++ {
+		sleep(time.Duration(attempt) * time.Second)
+		err = TryPush(create, items)
+	}
+	return err
+}
@@ -0,0 +1,322 @@
+package pusher
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	sync.Mutex
+	failures int
+	delay    time.Duration
+	pushes   [][]json.RawMessage
+}
+
+var recorders = map[string]*recorder{}
+
+type recorderSink struct {
+	recorder *recorder
+}
+
+func (s *recorderSink) Push(items []json.RawMessage) error {
+	time.Sleep(s.recorder.delay)
+	s.recorder.Lock()
+	defer s.recorder.Unlock()
+	if s.recorder.failures > 0 {
+		s.recorder.failures--
+		panic("Failed to connect to remote host.")
+	}
+	s.recorder.pushes = append(s.recorder.pushes, items)
+	return nil
+}
+
+// blockingSink pushes until it is closed.
+type blockingSink struct {
+	closed chan bool
+	ended  chan bool
+}
+
+var blocking = &blockingSink{}
+var blockingCreated = 0
+
+func (s *blockingSink) Push(items []json.RawMessage) error {
+	<-s.closed
+	s.ended <- true
+	return errors.New("Closed.")
+}
+
+func (s *blockingSink) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func init() {
+	Register("recorder", func(config Config) PusherInterface {
+		return &recorderSink{recorder: recorders[config("RECORDER")]}
+	})
+	Register("blocking", func(config Config) PusherInterface {
+		blockingCreated++
+		return blocking
+	})
+}
+
+func noSleep(t *testing.T) {
+	sleep = func(d time.Duration) {}
+	t.Cleanup(func() {
+		sleep = time.Sleep
+	})
+}
+
+func TestPageFannedOutToAllSinks(t *testing.T) {
+	noSleep(t)
+	recorders["siem"] = &recorder{}
+	recorders["platform"] = &recorder{}
+	items := []json.RawMessage{json.RawMessage(`{"id":"1"}`)}
+
+	fanOut := NewFanOut(configOf(map[string]string{
+		"FANOUT_SINKS":      "siem, platform",
+		"SIEM_SINK":         "recorder",
+		"SIEM_RECORDER":     "siem",
+		"PLATFORM_SINK":     "recorder",
+		"PLATFORM_RECORDER": "platform",
+	}))
+	err := fanOut.Push(items)
+
+	if err != nil {
+		t.Errorf("No error expected. %s", err)
+	}
+	if len(recorders["siem"].pushes) != 1 || len(recorders["platform"].pushes) != 1 {
+		t.Errorf("Page expected in both sinks.")
+	}
+}
+
+func TestRequiredSinkRetriedIndependently(t *testing.T) {
+	noSleep(t)
+	recorders["siem"] = &recorder{failures: 2}
+	recorders["platform"] = &recorder{}
+
+	fanOut := NewFanOut(configOf(map[string]string{
+		"FANOUT_SINKS":      "siem,platform",
+		"SINK":              "fanout",
+		"SIEM_SINK":         "recorder",
+		"SIEM_RECORDER":     "siem",
+		"PLATFORM_SINK":     "recorder",
+		"PLATFORM_RECORDER": "platform",
+	}))
+	err := fanOut.Push([]json.RawMessage{})
+
+	if err != nil {
+		t.Errorf("No error expected after retries. %s", err)
+	}
+	if len(recorders["siem"].pushes) != 1 || len(recorders["platform"].pushes) != 1 {
+		t.Errorf("Each sink expected to receive the page once.")
+	}
+}
+
+func TestFailedRequiredSinkFailsPush(t *testing.T) {
+	noSleep(t)
+	recorders["siem"] = &recorder{failures: 10}
+
+	fanOut := NewFanOut(configOf(map[string]string{
+		"FANOUT_SINKS":   "siem",
+		"SIEM_SINK":      "recorder",
+		"SIEM_RECORDER":  "siem",
+		"SIEM_RETRY_MAX": "2",
+	}))
+	err := fanOut.Push([]json.RawMessage{})
+
+	if err == nil || !strings.Contains(err.Error(), "siem: Failed to connect") {
+		t.Errorf("Required sink error expected. %s", err)
+	}
+	if recorders["siem"].failures != 7 {
+		t.Errorf("Three attempts expected, %d failures left.", recorders["siem"].failures)
+	}
+}
+
+func TestFailedBestEffortSinkCounted(t *testing.T) {
+	noSleep(t)
+	recorders["siem"] = &recorder{}
+	recorders["platform"] = &recorder{failures: 10}
+	failuresBefore := int64(0)
+	if counter := fanOutFailures.Get("platform"); counter != nil {
+		failuresBefore = counter.(*expvar.Int).Value()
+	}
+
+	fanOut := NewFanOut(configOf(map[string]string{
+		"FANOUT_SINKS":       "siem,platform",
+		"SIEM_SINK":          "recorder",
+		"SIEM_RECORDER":      "siem",
+		"PLATFORM_SINK":      "recorder",
+		"PLATFORM_RECORDER":  "platform",
+		"PLATFORM_POLICY":    "best-effort",
+		"PLATFORM_RETRY_MAX": "0",
+	}))
+	err := fanOut.Push([]json.RawMessage{})
+
+	if err != nil {
+		t.Errorf("Best-effort failure must not fail the push. %s", err)
+	}
+	if fanOutFailures.Get("platform").(*expvar.Int).Value() != failuresBefore+1 {
+		t.Errorf("Best-effort failure expected to be counted.")
+	}
+}
+
+func TestSlowBestEffortSinkNotWaitedForAfterTimeout(t *testing.T) {
+	noSleep(t)
+	recorders["siem"] = &recorder{}
+	recorders["platform"] = &recorder{delay: time.Second}
+
+	fanOut := NewFanOut(configOf(map[string]string{
+		"FANOUT_SINKS":      "siem,platform",
+		"SIEM_SINK":         "recorder",
+		"SIEM_RECORDER":     "siem",
+		"PLATFORM_SINK":     "recorder",
+		"PLATFORM_RECORDER": "platform",
+		"PLATFORM_POLICY":   "best-effort",
+		"PLATFORM_TIMEOUT":  "10ms",
+	}))
+	start := time.Now()
+	err := fanOut.Push([]json.RawMessage{})
+
+	if err != nil {
+		t.Errorf("No error expected. %s", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Slow best-effort sink stalled the push.")
+	}
+}
+
+func TestRetriedPageOnlyPushedToFailedSinks(t *testing.T) {
+	noSleep(t)
+	recorders["siem"] = &recorder{failures: 1}
+	recorders["platform"] = &recorder{}
+	items := []json.RawMessage{json.RawMessage(`{"id":"retried"}`)}
+	config := configOf(map[string]string{
+		"FANOUT_SINKS":      "siem,platform",
+		"SIEM_SINK":         "recorder",
+		"SIEM_RECORDER":     "siem",
+		"SIEM_RETRY_MAX":    "0",
+		"PLATFORM_SINK":     "recorder",
+		"PLATFORM_RECORDER": "platform",
+	})
+
+	if err := NewFanOut(config).Push(items); err == nil {
+		t.Errorf("Required sink error expected.")
+	}
+	err := NewFanOut(config).Push(items)
+
+	if err != nil {
+		t.Errorf("No error expected on retry. %s", err)
+	}
+	if len(recorders["siem"].pushes) != 1 || len(recorders["platform"].pushes) != 1 {
+		t.Errorf("Each sink expected to receive the page once, siem %d, platform %d.", len(recorders["siem"].pushes), len(recorders["platform"].pushes))
+	}
+
+	NewFanOut(config).Push(items)
+	if len(recorders["platform"].pushes) != 2 {
+		t.Errorf("Page pushed by every sink expected to be forgotten.")
+	}
+}
+
+func TestTimedOutSinkClosedAndNotRetried(t *testing.T) {
+	noSleep(t)
+	blocking = &blockingSink{closed: make(chan bool), ended: make(chan bool, 1)}
+	blockingCreated = 0
+
+	fanOut := NewFanOut(configOf(map[string]string{
+		"FANOUT_SINKS": "siem",
+		"SIEM_SINK":    "blocking",
+		"SIEM_TIMEOUT": "10ms",
+	}))
+	err := fanOut.Push([]json.RawMessage{})
+
+	if err == nil || !strings.Contains(err.Error(), "Timed out") {
+		t.Errorf("Timeout expected. %s", err)
+	}
+	select {
+	case <-blocking.ended:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out sink expected to be closed.")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if blockingCreated != 1 {
+		t.Errorf("No retry expected after the timeout, %d pushes.", blockingCreated)
+	}
+}
+
+func TestFanOutWithoutSinkTypeFailed(t *testing.T) {
+	defer func() {
+		f := recover()
+		if f == nil || !strings.Contains(f.(string), "Unknown sink type for siem") {
+			t.Errorf("Sink type panic expected. %s", f)
+		}
+	}()
+
+	NewFanOut(configOf(map[string]string{"FANOUT_SINKS": "siem", "SINK": "fanout"}))
+}
+
+func TestEventsRoutedToSinks(t *testing.T) {
+	noSleep(t)
+	recorders["siem"] = &recorder{}
+	recorders["platform"] = &recorder{}
+	complained := json.RawMessage(`{"_route":["siem"],"event":"complained","id":"1"}`)
+	delivered := json.RawMessage(`{"_route":["platform"],"event":"delivered","id":"2"}`)
+
+	fanOut := NewFanOut(configOf(map[string]string{
+		"FANOUT_SINKS":      "siem, platform",
+		"SIEM_SINK":         "recorder",
+		"SIEM_RECORDER":     "siem",
+		"PLATFORM_SINK":     "recorder",
+		"PLATFORM_RECORDER": "platform",
+	}))
+	err := fanOut.Push([]json.RawMessage{complained, delivered})
+
+	if err != nil {
+		t.Errorf("No error expected. %s", err)
+	}
+	if len(recorders["siem"].pushes) != 1 || string(recorders["siem"].pushes[0][0]) != `{"event":"complained","id":"1"}` || len(recorders["siem"].pushes[0]) != 1 {
+		t.Errorf("Only the complaint expected in siem. %s", recorders["siem"].pushes)
+	}
+	if len(recorders["platform"].pushes) != 1 || string(recorders["platform"].pushes[0][0]) != `{"event":"delivered","id":"2"}` || len(recorders["platform"].pushes[0]) != 1 {
+		t.Errorf("Only the delivery expected in platform. %s", recorders["platform"].pushes)
+	}
+
+	fanOut.Push([]json.RawMessage{delivered})
+	if len(recorders["siem"].pushes) != 1 {
+		t.Errorf("No push expected to siem without events routed to it.")
+	}
+}
+
+func TestRouteToUnknownSinkFailed(t *testing.T) {
+	defer func() {
+		f := recover()
+		if f == nil || !strings.Contains(f.(string), "not in FANOUT_SINKS: audit") {
+			t.Errorf("Unknown route sink panic expected. %s", f)
+		}
+	}()
+
+	CheckRoutes(configOf(map[string]string{
+		"SINK":         "fanout",
+		"FANOUT_SINKS": "siem",
+		"FILTER_RULES": `route audit event == "complained"`,
+	}))
+}
+
+func TestRoutesWithoutFanOutFailed(t *testing.T) {
+	defer func() {
+		f := recover()
+		if f == nil || f.(string) != "Route rules need SINK=fanout." {
+			t.Errorf("Fan-out panic expected. %s", f)
+		}
+	}()
+
+	CheckRoutes(configOf(map[string]string{
+		"SINK":         "syslog",
+		"FILTER_RULES": `route siem event == "complained"`,
+	}))
+}
@@ -0,0 +1,297 @@
+package pusher
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"matchwork/mailgun-log-fetcher/event"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const activeSuffix = ".jsonl"
+const rotatedSuffix = ".jsonl.gz"
+
+var periodLayouts = map[string]string{
+	"daily":  "2006-01-02",
+	"hourly": "2006-01-02T15",
+}
+
+// FilePusher archives events as JSON lines in files named by the date of
+// the events, like mailgun-2021-11-10.jsonl. A file is compressed to a
+// numbered part like mailgun-2021-11-10.1.jsonl.gz when it grows over the
+// size limit or when events of a later period arrive.
+type FilePusher struct {
+	dir          string
+	prefix       string
+	periodLayout string
+	maxBytes     int64
+	maxFiles     int
+	maxAge       time.Duration
+}
+
+func init() {
+	Register("file", NewFile)
+}
+
+func NewFile(config Config) PusherInterface {
+	dir := config("FILE_DIR")
+	if dir == "" {
+		panic("FILE_DIR is not set.")
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		panic(fmt.Sprintf("Failed to create file sink directory. %s", err))
+	}
+
+	prefix := config("FILE_PREFIX")
+	if prefix == "" {
+		prefix = "mailgun"
+	}
+	rotation := config("FILE_ROTATE_INTERVAL")
+	if rotation == "" {
+		rotation = "daily"
+	}
+	periodLayout, ok := periodLayouts[rotation]
+	if !ok {
+		panic(fmt.Sprintf("Unknown file rotation interval: %s", rotation))
+	}
+	maxBytes, _ := strconv.ParseInt(config("FILE_MAX_BYTES"), 10, 64)
+	maxFiles, _ := strconv.Atoi(config("FILE_MAX_FILES"))
+	maxAge, _ := time.ParseDuration(config("FILE_MAX_AGE"))
+
+	return &FilePusher{
+		dir:          dir,
+		prefix:       prefix,
+		periodLayout: periodLayout,
+		maxBytes:     maxBytes,
+		maxFiles:     maxFiles,
+		maxAge:       maxAge,
+	}
+}
+
+// Push returns only after the events are fsynced, so the cursor is advanced
+// only for durable events. Every file is synced once for the page.
+func (p *FilePusher) Push(items []json.RawMessage) error {
+	files := map[string]*activeFile{}
+	defer func() {
+		for _, file := range files {
+			file.file.Close()
+		}
+	}()
+
+	latest := ""
+	for _, item := range items {
+		period := event.Parse(item).Time().Format(p.periodLayout)
+		file, ok := files[period]
+		if !ok {
+			var err error
+			if file, err = openActive(p.activePath(period)); err != nil {
+				return err
+			}
+			files[period] = file
+		}
+		if err := file.write(item); err != nil {
+			return err
+		}
+		if p.maxBytes > 0 && file.size >= p.maxBytes {
+			delete(files, period)
+			if err := file.finish(); err != nil {
+				return err
+			}
+			if err := p.rotate(period); err != nil {
+				return err
+			}
+		}
+		if period > latest {
+			latest = period
+		}
+	}
+	for period, file := range files {
+		delete(files, period)
+		if err := file.finish(); err != nil {
+			return err
+		}
+	}
+
+	if err := syncDir(p.dir); err != nil {
+		return err
+	}
+	if err := p.rotateBefore(latest); err != nil {
+		return err
+	}
+	return p.removeExpired()
+}
+
+// activeFile is an active file appended by a push.
+type activeFile struct {
+	file   *os.File
+	writer *bufio.Writer
+	size   int64
+}
+
+func openActive(path string) (*activeFile, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &activeFile{file: file, writer: bufio.NewWriter(file), size: info.Size()}, nil
+}
+
+func (f *activeFile) write(item json.RawMessage) error {
+	if _, err := f.writer.Write(item); err != nil {
+		return err
+	}
+	if err := f.writer.WriteByte('\n'); err != nil {
+		return err
+	}
+	f.size += int64(len(item)) + 1
+	return nil
+}
+
+// finish writes out the buffer, syncs and closes the file.
+func (f *activeFile) finish() error {
+	defer f.file.Close()
+	if err := f.writer.Flush(); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+// rotateBefore compresses the active files of the periods before the latest.
+func (p *FilePusher) rotateBefore(latest string) error {
+	active, err := filepath.Glob(filepath.Join(p.dir, p.prefix+"-*"+activeSuffix))
+	if err != nil {
+		return err
+	}
+	for _, path := range active {
+		period := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), p.prefix+"-"), activeSuffix)
+		if period < latest {
+			if err := p.rotate(period); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rotate compresses the active file of the period to the part after the
+// last one, parts removed by FILE_MAX_FILES or FILE_MAX_AGE leave gaps.
+func (p *FilePusher) rotate(period string) error {
+	parts, err := filepath.Glob(filepath.Join(p.dir, p.prefix+"-"+period+".*"+rotatedSuffix))
+	if err != nil {
+		return err
+	}
+	last := 0
+	for _, part := range parts {
+		name := strings.TrimSuffix(filepath.Base(part), rotatedSuffix)
+		index, err := strconv.Atoi(name[strings.LastIndex(name, ".")+1:])
+		if err == nil && index > last {
+			last = index
+		}
+	}
+	target := filepath.Join(p.dir, fmt.Sprintf("%s-%s.%d%s", p.prefix, period, last+1, rotatedSuffix))
+	source := p.activePath(period)
+
+	if err := compress(source, target); err != nil {
+		return err
+	}
+	if err := os.Remove(source); err != nil {
+		return err
+	}
+	return syncDir(p.dir)
+}
+
+// compress writes the gzip of source to target, an existing target is not
+// overwritten.
+func compress(source string, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	temporary := target + ".tmp"
+	out, err := os.Create(temporary)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(out)
+	if _, err := io.Copy(writer, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	out.Close()
+
+	defer os.Remove(temporary)
+	if err := os.Link(temporary, target); err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("Rotated file %s already exists.", target)
+		}
+		return err
+	}
+	return nil
+}
+
+// removeExpired enforces FILE_MAX_FILES and FILE_MAX_AGE on compressed files.
+func (p *FilePusher) removeExpired() error {
+	files, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return err
+	}
+	var rotated []os.FileInfo
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), p.prefix+"-") && strings.HasSuffix(file.Name(), rotatedSuffix) {
+			rotated = append(rotated, file)
+		}
+	}
+	sort.Slice(rotated, func(i, j int) bool {
+		if rotated[i].ModTime().Equal(rotated[j].ModTime()) {
+			return rotated[i].Name() > rotated[j].Name()
+		}
+		return rotated[i].ModTime().After(rotated[j].ModTime())
+	})
+
+	for index, file := range rotated {
+		tooMany := p.maxFiles > 0 && index >= p.maxFiles
+		tooOld := p.maxAge > 0 && time.Since(file.ModTime()) > p.maxAge
+		if tooMany || tooOld {
+			if err := os.Remove(filepath.Join(p.dir, file.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *FilePusher) activePath(period string) string {
+	return filepath.Join(p.dir, p.prefix+"-"+period+activeSuffix)
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
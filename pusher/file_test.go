@@ -0,0 +1,161 @@
+package pusher
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func filesIn(dir string) []string {
+	var names []string
+	files, _ := ioutil.ReadDir(dir)
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readGzip(t *testing.T, path string) string {
+	file, _ := os.Open(path)
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(reader)
+	return string(content)
+}
+
+var novemberTenth = json.RawMessage(`{"id":"1","timestamp":1636532241.823582}`)
+var novemberTenthLater = json.RawMessage(`{"id":"2","timestamp":1636532679.442269}`)
+var novemberEleventh = json.RawMessage(`{"id":"3","timestamp":1636646172.343453}`)
+
+func TestEventsWrittenToFileOfEventDate(t *testing.T) {
+	dir := t.TempDir()
+
+	err := NewFile(configOf(map[string]string{"FILE_DIR": dir})).Push([]json.RawMessage{novemberTenth, novemberTenthLater})
+
+	assertNoErrors(t, err)
+	content, _ := ioutil.ReadFile(dir + "/mailgun-2021-11-10.jsonl")
+	if string(content) != string(novemberTenth)+"\n"+string(novemberTenthLater)+"\n" {
+		t.Errorf("Json lines expected, got %s", content)
+	}
+}
+
+func TestPreviousDayCompressedWhenNewDayArrives(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewFile(configOf(map[string]string{"FILE_DIR": dir}))
+
+	sink.Push([]json.RawMessage{novemberTenth})
+	err := sink.Push([]json.RawMessage{novemberEleventh})
+
+	assertNoErrors(t, err)
+	if strings.Join(filesIn(dir), ",") != "mailgun-2021-11-10.1.jsonl.gz,mailgun-2021-11-11.jsonl" {
+		t.Errorf("Rotated previous day expected, got %v", filesIn(dir))
+	}
+	if readGzip(t, dir+"/mailgun-2021-11-10.1.jsonl.gz") != string(novemberTenth)+"\n" {
+		t.Errorf("Compressed events expected.")
+	}
+}
+
+func TestFileRotatedBySize(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewFile(configOf(map[string]string{"FILE_DIR": dir, "FILE_MAX_BYTES": "10", "FILE_PREFIX": "events"}))
+
+	err := sink.Push([]json.RawMessage{novemberTenth, novemberTenthLater})
+
+	assertNoErrors(t, err)
+	if strings.Join(filesIn(dir), ",") != "events-2021-11-10.1.jsonl.gz,events-2021-11-10.2.jsonl.gz" {
+		t.Errorf("Part per event expected, got %v", filesIn(dir))
+	}
+	if readGzip(t, dir+"/events-2021-11-10.2.jsonl.gz") != string(novemberTenthLater)+"\n" {
+		t.Errorf("Second event expected in second part.")
+	}
+}
+
+func TestHourlyRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	NewFile(configOf(map[string]string{"FILE_DIR": dir, "FILE_ROTATE_INTERVAL": "hourly"})).Push([]json.RawMessage{novemberTenth})
+
+	if strings.Join(filesIn(dir), ",") != "mailgun-2021-11-10T08.jsonl" {
+		t.Errorf("Hourly file expected, got %v", filesIn(dir))
+	}
+}
+
+func TestRetainedFileCountEnforced(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewFile(configOf(map[string]string{"FILE_DIR": dir, "FILE_MAX_BYTES": "10", "FILE_MAX_FILES": "1"}))
+
+	sink.Push([]json.RawMessage{novemberTenth})
+	sink.Push([]json.RawMessage{novemberTenthLater})
+
+	if strings.Join(filesIn(dir), ",") != "mailgun-2021-11-10.2.jsonl.gz" {
+		t.Errorf("Newest part expected only, got %v", filesIn(dir))
+	}
+}
+
+func TestPartsAfterRemovedPartsNotOverwritten(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewFile(configOf(map[string]string{"FILE_DIR": dir, "FILE_MAX_BYTES": "10", "FILE_MAX_FILES": "2"}))
+	third := json.RawMessage(`{"id":"3","timestamp":1636532700.1}`)
+	fourth := json.RawMessage(`{"id":"4","timestamp":1636532800.1}`)
+
+	for _, item := range []json.RawMessage{novemberTenth, novemberTenthLater, third, fourth} {
+		assertNoErrors(t, sink.Push([]json.RawMessage{item}))
+	}
+
+	if strings.Join(filesIn(dir), ",") != "mailgun-2021-11-10.3.jsonl.gz,mailgun-2021-11-10.4.jsonl.gz" {
+		t.Errorf("Newest parts expected, got %v", filesIn(dir))
+	}
+	if readGzip(t, dir+"/mailgun-2021-11-10.3.jsonl.gz") != string(third)+"\n" {
+		t.Errorf("Third event expected in its part.")
+	}
+}
+
+func TestExistingPartNotOverwritten(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(dir+"/active.jsonl", []byte("{}\n"), 0640)
+	ioutil.WriteFile(dir+"/part.jsonl.gz", []byte("archived"), 0640)
+
+	err := compress(dir+"/active.jsonl", dir+"/part.jsonl.gz")
+
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Existing part error expected. %s", err)
+	}
+	content, _ := ioutil.ReadFile(dir + "/part.jsonl.gz")
+	if string(content) != "archived" || strings.Join(filesIn(dir), ",") != "active.jsonl,part.jsonl.gz" {
+		t.Errorf("Existing part expected to be kept, got %v", filesIn(dir))
+	}
+}
+
+func TestRetainedFileAgeEnforced(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(dir+"/mailgun-2020-01-01.1.jsonl.gz", []byte{}, 0640)
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(dir+"/mailgun-2020-01-01.1.jsonl.gz", old, old)
+
+	NewFile(configOf(map[string]string{"FILE_DIR": dir, "FILE_MAX_AGE": "24h"})).Push([]json.RawMessage{novemberTenth})
+
+	if _, err := os.Stat(filepath.Join(dir, "mailgun-2020-01-01.1.jsonl.gz")); !os.IsNotExist(err) {
+		t.Errorf("Old file removal expected.")
+	}
+}
+
+func TestFileSinkWithoutDirFailed(t *testing.T) {
+	defer func() {
+		f := recover()
+		if f == nil || !strings.Contains(f.(string), "FILE_DIR") {
+			t.Errorf("Missing dir panic expected. %s", f)
+		}
+	}()
+
+	NewFile(configOf(map[string]string{"FILE_DIR": ""}))
+}
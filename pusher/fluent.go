@@ -0,0 +1,154 @@
+package pusher
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"matchwork/mailgun-log-fetcher/event"
+	"net"
+	"strings"
+	"time"
+)
+
+const defaultFluentTag = "mailgun.{domain}.{event}"
+const defaultFluentAckTimeout = 30 * time.Second
+
+type fluentEntry struct {
+	time   time.Time
+	record map[string]interface{}
+}
+
+// FluentPusher sends events to Fluentd or Fluent Bit with the forward
+// protocol, one message per tag. With ack on, a page is pushed only when
+// every message was acknowledged.
+type FluentPusher struct {
+	connection net.Conn
+	reader     *bufio.Reader
+	tag        string
+	domain     string
+	packed     bool
+	ack        bool
+	ackTimeout time.Duration
+}
+
+func init() {
+	Register("fluent", NewFluent)
+}
+
+func NewFluent(config Config) PusherInterface {
+	mode := config("FLUENT_MODE")
+	if mode != "" && mode != "forward" && mode != "packed" {
+		panic(fmt.Sprintf("Unknown fluent mode: %s", mode))
+	}
+
+	if !strings.Contains(config("FLUENT_ADDRESS"), "://") {
+		panic(fmt.Sprintf("FLUENT_ADDRESS needs a tcp:// or tls:// scheme: %s", config("FLUENT_ADDRESS")))
+	}
+	network, address := parseRemoteHost(config("FLUENT_ADDRESS"))
+	var con net.Conn
+	var err error
+	switch network {
+	case "tcp":
+		con, err = net.Dial("tcp", address)
+	case "tls":
+		con, err = tls.Dial("tcp", address, tlsConfig(config, "FLUENT_TLS_"))
+	default:
+		panic(fmt.Sprintf("Unsupported fluent address scheme: %s", network))
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Failed to connect to fluent. %s", err))
+	}
+
+	tag := config("FLUENT_TAG")
+	if tag == "" {
+		tag = defaultFluentTag
+	}
+	return &FluentPusher{
+		connection: con,
+		reader:     bufio.NewReader(con),
+		tag:        tag,
+		domain:     config("MAIL_DOMAIN"),
+		packed:     mode == "packed",
+		ack:        config("FLUENT_ACK") == "true",
+		ackTimeout: durationOr(config("FLUENT_ACK_TIMEOUT"), defaultFluentAckTimeout),
+	}
+}
+
+func (p *FluentPusher) Push(items []json.RawMessage) error {
+	defer p.connection.Close()
+
+	var tags []string
+	entries := map[string][]fluentEntry{}
+	for _, item := range items {
+		e := event.Parse(item)
+		tag := expandPattern(p.tag, p.domain, e)
+		if _, ok := entries[tag]; !ok {
+			tags = append(tags, tag)
+		}
+		entries[tag] = append(entries[tag], fluentEntry{time: e.Time(), record: event.Decode(item)})
+	}
+
+	for _, tag := range tags {
+		if err := p.send(tag, entries[tag]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close aborts a push in progress.
+func (p *FluentPusher) Close() error {
+	return p.connection.Close()
+}
+
+func (p *FluentPusher) send(tag string, entries []fluentEntry) error {
+	message := msgpack{}.Array(3).String(tag)
+	if p.packed {
+		var stream msgpack
+		for _, entry := range entries {
+			stream = stream.Array(2).EventTime(entry.time).Value(entry.record)
+		}
+		message = message.Binary(stream)
+	} else {
+		message = message.Array(len(entries))
+		for _, entry := range entries {
+			message = message.Array(2).EventTime(entry.time).Value(entry.record)
+		}
+	}
+
+	chunk := ""
+	if p.ack {
+		id := make([]byte, 16)
+		rand.Read(id)
+		chunk = base64.StdEncoding.EncodeToString(id)
+		message = message.Map(2).String("size").Int(int64(len(entries))).String("chunk").String(chunk)
+	} else {
+		message = message.Map(1).String("size").Int(int64(len(entries)))
+	}
+
+	if _, err := p.connection.Write(message); err != nil {
+		return err
+	}
+	if p.ack {
+		return p.waitForAck(chunk)
+	}
+	return nil
+}
+
+func (p *FluentPusher) waitForAck(chunk string) error {
+	p.connection.SetReadDeadline(time.Now().Add(p.ackTimeout))
+	defer p.connection.SetReadDeadline(time.Time{})
+
+	response, err := readMsgpack(p.reader)
+	if err != nil {
+		return fmt.Errorf("No fluent ack for chunk %s. %s", chunk, err)
+	}
+	ack, _ := response.(map[string]interface{})
+	if ack["ack"] != chunk {
+		return fmt.Errorf("Fluent ack %v does not match chunk %s.", ack["ack"], chunk)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package pusher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+type fluentMessage struct {
+	tag     string
+	entries []interface{}
+	option  map[string]interface{}
+}
+
+// listenAsFluent decodes the forward messages sent to it, acknowledging the
+// chunks when ack is true.
+func listenAsFluent(t *testing.T, ack bool) (net.Listener, chan fluentMessage) {
+	ln, _ := net.Listen("tcp", "localhost:0")
+	messages := make(chan fluentMessage, 10)
+	go func() {
+		con, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer con.Close()
+		reader := bufio.NewReader(con)
+		for {
+			decoded, err := readMsgpack(reader)
+			if err != nil {
+				close(messages)
+				return
+			}
+			message := decoded.([]interface{})
+			option := message[2].(map[string]interface{})
+			entries, ok := message[1].([]interface{})
+			if !ok {
+				packed := bufio.NewReader(bytes.NewReader([]byte(message[1].(string))))
+				for {
+					entry, err := readMsgpack(packed)
+					if err != nil {
+						break
+					}
+					entries = append(entries, entry)
+				}
+			}
+			messages <- fluentMessage{tag: message[0].(string), entries: entries, option: option}
+			if ack {
+				con.Write(msgpack{}.Map(1).String("ack").String(option["chunk"].(string)))
+			}
+		}
+	}()
+	return ln, messages
+}
+
+var fluentItems = []json.RawMessage{
+	json.RawMessage(`{"event":"delivered","id":"1","timestamp":1636532734.023108}`),
+	json.RawMessage(`{"event":"failed","id":"2","timestamp":1636532735}`),
+	json.RawMessage(`{"event":"delivered","id":"3","timestamp":1636532736}`),
+}
+
+var fluentSettings = map[string]string{
+	"MAIL_DOMAIN": "mg.example.com",
+}
+
+func TestEventsForwardedByTag(t *testing.T) {
+	ln, messages := listenAsFluent(t, false)
+	defer ln.Close()
+
+	err := NewFluent(configOf(fluentSettings, map[string]string{"FLUENT_ADDRESS": "tcp://" + ln.Addr().String()})).Push(fluentItems)
+
+	assertNoErrors(t, err)
+	delivered, failed := <-messages, <-messages
+	if delivered.tag != "mailgun.mg.example.com.delivered" || failed.tag != "mailgun.mg.example.com.failed" {
+		t.Errorf("Tag per event type expected, got %s and %s", delivered.tag, failed.tag)
+	}
+	if len(delivered.entries) != 2 || len(failed.entries) != 1 {
+		t.Fatalf("Entries grouped by tag expected.")
+	}
+	record := delivered.entries[1].([]interface{})[1].(map[string]interface{})
+	if record["id"] != "3" || record["timestamp"] != int64(1636532736) {
+		t.Errorf("Event as record expected. %v", record)
+	}
+	if delivered.option["size"] != int64(2) {
+		t.Errorf("Size option expected.")
+	}
+}
+
+func TestPackedForwardWithAck(t *testing.T) {
+	ln, messages := listenAsFluent(t, true)
+	defer ln.Close()
+
+	err := NewFluent(configOf(fluentSettings, map[string]string{
+		"FLUENT_ADDRESS": "tcp://" + ln.Addr().String(),
+		"FLUENT_MODE":    "packed",
+		"FLUENT_ACK":     "true",
+		"FLUENT_TAG":     "mail.{event}",
+	})).Push(fluentItems)
+
+	assertNoErrors(t, err)
+	message := <-messages
+	if message.tag != "mail.delivered" || len(message.entries) != 2 || message.option["chunk"] == "" {
+		t.Errorf("Packed entries with chunk expected. %v", message)
+	}
+}
+
+func TestMissingAckFailsPush(t *testing.T) {
+	ln, _ := listenAsFluent(t, false)
+	defer ln.Close()
+
+	err := NewFluent(configOf(fluentSettings, map[string]string{
+		"FLUENT_ADDRESS":     "tcp://" + ln.Addr().String(),
+		"FLUENT_ACK":         "true",
+		"FLUENT_ACK_TIMEOUT": "50ms",
+	})).Push(fluentItems)
+
+	if err == nil || !strings.Contains(err.Error(), "No fluent ack") {
+		t.Errorf("Ack error expected. %s", err)
+	}
+}
+
+func TestFluentTlsConfiguredWithItsOwnSettings(t *testing.T) {
+	ca := createCertificate("ca", nil)
+	ln, clients := listenWithClientAuth(t, ca, createCertificate("localhost", ca))
+	defer ln.Close()
+	caFile, _ := ca.write(t, "ca")
+	certFile, keyFile := createCertificate("fluent-client", ca).write(t, "client")
+
+	err := NewFluent(configOf(fluentSettings, map[string]string{
+		"FLUENT_ADDRESS":         "tls://" + ln.Addr().String(),
+		"FLUENT_TLS_CA_FILE":     caFile,
+		"FLUENT_TLS_CERT_FILE":   certFile,
+		"FLUENT_TLS_KEY_FILE":    keyFile,
+		"FLUENT_TLS_SERVER_NAME": "localhost",
+	})).Push(fluentItems)
+
+	assertNoErrors(t, err)
+	if client := <-clients; client != "fluent-client" {
+		t.Errorf("Client certificate of the fluent settings expected, got %s", client)
+	}
+}
+
+func TestFluentAddressWithoutSchemeFailed(t *testing.T) {
+	defer func() {
+		f := recover()
+		if f == nil || !strings.Contains(f.(string), "FLUENT_ADDRESS needs a tcp:// or tls:// scheme") {
+			t.Errorf("Scheme panic expected. %s", f)
+		}
+	}()
+
+	NewFluent(configOf(fluentSettings, map[string]string{"FLUENT_ADDRESS": "localhost:24224"}))
+}
@@ -0,0 +1,198 @@
+package pusher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"matchwork/mailgun-log-fetcher/event"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const defaultGelfChunkSize = 1420
+const maxGelfChunks = 128
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+var invalidGelfField = regexp.MustCompile(`[^\w.\-]`)
+
+// GelfPusher sends events as GELF 1.1 messages to Graylog over TCP with null
+// byte framing, over UDP compressed and chunked, or over HTTP.
+type GelfPusher struct {
+	host       string
+	connection ConnInterface
+	datagram   bool
+	compress   bool
+	chunkSize  int
+	endpoint   *httpEndpoint
+}
+
+func init() {
+	Register("gelf", NewGelf)
+}
+
+func NewGelf(config Config) PusherInterface {
+	address := config("GELF_ADDRESS")
+	network, hostPort := parseRemoteHost(address)
+	pusher := &GelfPusher{host: config("LOG_HOSTNAME")}
+
+	switch network {
+	case "http", "https":
+		pusher.endpoint = newHttpEndpoint(func(key string) string {
+			if key == "GELF_URL" {
+				return address
+			}
+			return config(key)
+		}, "GELF_")
+		return pusher
+	case "tcp", "udp":
+	default:
+		panic(fmt.Sprintf("Unsupported GELF address scheme: %s", network))
+	}
+
+	con, err := net.Dial(network, hostPort)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to connect to GELF input. %s", err))
+	}
+	pusher.connection = con
+	pusher.datagram = network == "udp"
+	pusher.compress = config("GELF_COMPRESS") != "false"
+	pusher.chunkSize, err = strconv.Atoi(config("GELF_CHUNK_SIZE"))
+	if err != nil || pusher.chunkSize <= 12 {
+		pusher.chunkSize = defaultGelfChunkSize
+	}
+	return pusher
+}
+
+func (p *GelfPusher) Push(items []json.RawMessage) error {
+	if p.connection != nil {
+		defer p.connection.Close()
+	}
+
+	for _, item := range items {
+		message, err := json.Marshal(gelfMessage(p.host, item))
+		if err != nil {
+			return err
+		}
+		if err := p.send(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close aborts a push in progress.
+func (p *GelfPusher) Close() error {
+	if p.endpoint != nil {
+		p.endpoint.close()
+		return nil
+	}
+	return p.connection.Close()
+}
+
+func (p *GelfPusher) send(message []byte) error {
+	if p.endpoint != nil {
+		_, err := p.endpoint.post("", message, "application/json")
+		return err
+	}
+	if !p.datagram {
+		_, err := p.connection.Write(append(message, 0))
+		return err
+	}
+
+	if p.compress {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(message)
+		writer.Close()
+		message = compressed.Bytes()
+	}
+	chunks := chunk(message, p.chunkSize)
+	if len(chunks) > maxGelfChunks {
+		log.Printf("GELF message of %d bytes dropped, it needs more than %d chunks.", len(message), maxGelfChunks)
+		return nil
+	}
+	for _, datagram := range chunks {
+		if _, err := p.connection.Write(datagram); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunk splits a message to GELF chunks, each with the magic bytes, the
+// message ID, the sequence number and the count of chunks before the data.
+func chunk(message []byte, size int) [][]byte {
+	if len(message) <= size {
+		return [][]byte{message}
+	}
+
+	dataSize := size - 12
+	count := (len(message) + dataSize - 1) / dataSize
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	var chunks [][]byte
+	for sequence := 0; sequence < count; sequence++ {
+		end := (sequence + 1) * dataSize
+		if end > len(message) {
+			end = len(message)
+		}
+		header := append(append(append([]byte{}, gelfChunkMagic...), id...), byte(sequence), byte(count))
+		chunks = append(chunks, append(header, message[sequence*dataSize:end]...))
+	}
+	return chunks
+}
+
+// gelfMessage maps an event to GELF. The fields of the event are added as
+// additional fields, nested fields flattened with _ like _message_headers_to.
+func gelfMessage(host string, item json.RawMessage) map[string]interface{} {
+	e := event.Parse(item)
+	message := map[string]interface{}{
+		"version":       "1.1",
+		"host":          host,
+		"short_message": strings.TrimSpace(e.Event + " " + e.Recipient),
+		"timestamp":     e.Timestamp,
+		"level":         e.Severity(),
+	}
+	if message["short_message"] == "" {
+		message["short_message"] = "mailgun event"
+	}
+
+	for name, value := range event.Decode(item) {
+		switch name {
+		case "timestamp":
+		case "id":
+			message["_mailgun_id"] = value
+		default:
+			flatten(message, "_"+name, value)
+		}
+	}
+	return message
+}
+
+func flatten(message map[string]interface{}, name string, value interface{}) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range typed {
+			flatten(message, name+"_"+key, nested)
+		}
+	case []interface{}:
+		encoded, _ := json.Marshal(typed)
+		message[gelfFieldName(name)] = string(encoded)
+	case bool:
+		message[gelfFieldName(name)] = strconv.FormatBool(typed)
+	case nil:
+	default:
+		message[gelfFieldName(name)] = typed
+	}
+}
+
+// gelfFieldName replaces the characters GELF does not allow in field names.
+func gelfFieldName(name string) string {
+	return invalidGelfField.ReplaceAllString(name, "_")
+}
@@ -0,0 +1,153 @@
+package pusher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var gelfItem = json.RawMessage(`{"id":"EEbmpTfvS2amOYYAK8JsIA","event":"failed","severity":"permanent","recipient":"info@example.com","timestamp":1636532734.023108,` +
+	`"message":{"headers":{"to":"info@example.com"},"size":50994},"tags":["a"],"flags":{"is-test-mode":false},"user-variables":{"my var":"x"}}`)
+
+func decodeGelf(t *testing.T, message []byte) map[string]interface{} {
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(message, &decoded); err != nil {
+		t.Fatalf("Json message expected. %s", err)
+	}
+	return decoded
+}
+
+var gelfSettings = map[string]string{
+	"LOG_HOSTNAME": "somehost",
+}
+
+func TestEventMappedToGelf(t *testing.T) {
+	message := gelfMessage("somehost", gelfItem)
+
+	expected := map[string]interface{}{
+		"version":                "1.1",
+		"host":                   "somehost",
+		"short_message":          "failed info@example.com",
+		"timestamp":              1636532734.023108,
+		"level":                  3,
+		"_mailgun_id":            "EEbmpTfvS2amOYYAK8JsIA",
+		"_event":                 "failed",
+		"_severity":              "permanent",
+		"_recipient":             "info@example.com",
+		"_message_headers_to":    "info@example.com",
+		"_message_size":          json.Number("50994"),
+		"_tags":                  `["a"]`,
+		"_flags_is-test-mode":    "false",
+		"_user-variables_my_var": "x",
+	}
+	if len(message) != len(expected) {
+		t.Errorf("Failed asserting %v is equal with %v", message, expected)
+	}
+	for name, value := range expected {
+		if message[name] != value {
+			t.Errorf("Field %s expected to be %v, got %v", name, value, message[name])
+		}
+	}
+}
+
+func TestGelfSentOverTcpWithNullByte(t *testing.T) {
+	ln, _ := net.Listen("tcp", "localhost:0")
+	defer ln.Close()
+	received := make(chan []byte)
+	go func() {
+		con, _ := ln.Accept()
+		content, _ := ioutil.ReadAll(con)
+		received <- content
+	}()
+
+	err := NewGelf(configOf(gelfSettings, map[string]string{"GELF_ADDRESS": "tcp://" + ln.Addr().String()})).Push([]json.RawMessage{gelfItem, gelfItem})
+
+	assertNoErrors(t, err)
+	frames := bytes.Split(<-received, []byte{0})
+	if len(frames) != 3 || len(frames[2]) != 0 {
+		t.Fatalf("Two null terminated frames expected, got %d", len(frames)-1)
+	}
+	if decodeGelf(t, frames[0])["short_message"] != "failed info@example.com" {
+		t.Errorf("GELF message expected.")
+	}
+}
+
+func TestGelfSentOverUdpCompressedAndChunked(t *testing.T) {
+	con, _ := net.ListenPacket("udp", "localhost:0")
+	defer con.Close()
+
+	err := NewGelf(configOf(gelfSettings, map[string]string{"GELF_ADDRESS": "udp://" + con.LocalAddr().String(), "GELF_CHUNK_SIZE": "100"})).Push([]json.RawMessage{gelfItem})
+	assertNoErrors(t, err)
+
+	var chunks [][]byte
+	buffer := make([]byte, 65536)
+	for {
+		con.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := con.ReadFrom(buffer)
+		if err != nil {
+			break
+		}
+		chunks = append(chunks, append([]byte{}, buffer[:n]...))
+	}
+
+	if len(chunks) < 2 {
+		t.Fatalf("Chunks expected, got %d", len(chunks))
+	}
+	var compressed []byte
+	for sequence, chunk := range chunks {
+		if !bytes.Equal(chunk[:2], gelfChunkMagic) || !bytes.Equal(chunk[2:10], chunks[0][2:10]) {
+			t.Errorf("Chunk header with same message ID expected.")
+		}
+		if int(chunk[10]) != sequence || int(chunk[11]) != len(chunks) || len(chunk) > 100 {
+			t.Errorf("Chunk %d of %d expected, got %d of %d", sequence, len(chunks), chunk[10], chunk[11])
+		}
+		compressed = append(compressed, chunk[12:]...)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("Gzip message expected. %s", err)
+	}
+	message, _ := ioutil.ReadAll(reader)
+	if decodeGelf(t, message)["_mailgun_id"] != "EEbmpTfvS2amOYYAK8JsIA" {
+		t.Errorf("GELF message expected.")
+	}
+}
+
+func TestSmallGelfMessageSentInOneDatagram(t *testing.T) {
+	if chunks := chunk([]byte("short"), 100); len(chunks) != 1 || string(chunks[0]) != "short" {
+		t.Errorf("Unchunked message expected.")
+	}
+}
+
+func TestGelfSentOverHttp(t *testing.T) {
+	collector := &fakeCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	err := NewGelf(configOf(gelfSettings, map[string]string{"GELF_ADDRESS": server.URL + "/gelf"})).Push([]json.RawMessage{gelfItem, gelfItem})
+
+	assertNoErrors(t, err)
+	if len(collector.requests) != 2 {
+		t.Fatalf("Request per message expected, got %d", len(collector.requests))
+	}
+	if decodeGelf(t, []byte(collector.requests[0].body))["level"] != float64(3) {
+		t.Errorf("GELF message expected.")
+	}
+}
+
+func TestUnsupportedGelfSchemeFailed(t *testing.T) {
+	defer func() {
+		f := recover()
+		if f == nil || !strings.Contains(f.(string), "Unsupported GELF address scheme") {
+			t.Errorf("Scheme panic expected. %s", f)
+		}
+	}()
+
+	NewGelf(configOf(gelfSettings, map[string]string{"GELF_ADDRESS": "graylog:12201"}))
+}
@@ -0,0 +1,250 @@
+package pusher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/go-retryablehttp"
+	"io/ioutil"
+	"matchwork/mailgun-log-fetcher/batch"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultBatchSize = 100
+
+// httpEndpoint posts to a URL with the headers, authentication, compression
+// and retries configured with the settings starting with prefix, like
+// HTTP_URL, HTTP_BEARER_TOKEN. Requests failing with 5xx or 429 are retried.
+type httpEndpoint struct {
+	url     string
+	headers http.Header
+	gzip    bool
+	client  *retryablehttp.Client
+	context context.Context
+	cancel  context.CancelFunc
+}
+
+func newHttpEndpoint(config Config, prefix string) *httpEndpoint {
+	endpoint := &httpEndpoint{
+		url:     config(prefix + "URL"),
+		headers: parseHeaders(config(prefix + "HEADERS")),
+		gzip:    config(prefix+"GZIP") == "true",
+		client:  retryablehttp.NewClient(),
+	}
+	endpoint.context, endpoint.cancel = context.WithCancel(context.Background())
+	if endpoint.url == "" {
+		panic(fmt.Sprintf("%sURL is not set.", prefix))
+	}
+
+	if token := config(prefix + "BEARER_TOKEN"); token != "" {
+		endpoint.headers.Set("Authorization", "Bearer "+token)
+	}
+	if username := config(prefix + "BASIC_USERNAME"); username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + config(prefix+"BASIC_PASSWORD")))
+		endpoint.headers.Set("Authorization", "Basic "+auth)
+	}
+
+	if retryMax, err := strconv.Atoi(config(prefix + "RETRY_MAX")); err == nil {
+		endpoint.client.RetryMax = retryMax
+	}
+	if retryWait, err := time.ParseDuration(config(prefix + "RETRY_WAIT")); err == nil {
+		endpoint.client.RetryWaitMin = retryWait
+	}
+	return endpoint
+}
+
+// parseHeaders reads headers in the form "Name: value; Other-Name: value".
+func parseHeaders(headers string) http.Header {
+	parsed := http.Header{}
+	for _, header := range strings.Split(headers, ";") {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) == 2 {
+			parsed.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		}
+	}
+	return parsed
+}
+
+// post sends the body and returns the response body of a 2xx response.
+func (e *httpEndpoint) post(path string, body []byte, contentType string) ([]byte, error) {
+	request, err := e.request(path, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := e.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	responseBody, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return responseBody, &statusError{statusCode: response.StatusCode, body: responseBody}
+	}
+	return responseBody, nil
+}
+
+func (e *httpEndpoint) request(path string, body []byte, contentType string) (*retryablehttp.Request, error) {
+	if e.gzip {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(body)
+		writer.Close()
+		body = compressed.Bytes()
+	}
+
+	request, err := retryablehttp.NewRequest("POST", e.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(e.context)
+	for name, values := range e.headers {
+		request.Header[name] = values
+	}
+	request.Header.Set("Content-Type", contentType)
+	if e.gzip {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+	return request, nil
+}
+
+// close aborts the requests in progress and fails the following ones.
+func (e *httpEndpoint) close() {
+	e.cancel()
+}
+
+type statusError struct {
+	statusCode int
+	body       []byte
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("Statuscode was %d. %s", e.statusCode, e.body)
+}
+
+// HttpPusher posts the events in batches as a JSON array or as NDJSON. With
+// a flush interval, a batch which is not full waits for the events of other
+// pushes until the interval passed, and the push returns once it is posted.
+type HttpPusher struct {
+	endpoint  *httpEndpoint
+	ndjson    bool
+	batchSize int
+	batcher   *batch.Batcher
+}
+
+// httpBatchers are the batchers by endpoint settings, shared by the sinks
+// which are created for every push.
+var httpBatchers = map[string]*batch.Batcher{}
+var httpBatchersMutex sync.Mutex
+
+func init() {
+	Register("http", NewHttp)
+}
+
+func NewHttp(config Config) PusherInterface {
+	format := config("HTTP_FORMAT")
+	if format != "" && format != "json" && format != "ndjson" {
+		panic(fmt.Sprintf("Unknown http format: %s", format))
+	}
+
+	pusher := &HttpPusher{
+		endpoint:  newHttpEndpoint(config, "HTTP_"),
+		ndjson:    format == "ndjson",
+		batchSize: batchSize(config("HTTP_BATCH_SIZE")),
+	}
+	if flushInterval, _ := time.ParseDuration(config("HTTP_FLUSH_INTERVAL")); flushInterval > 0 {
+		pusher.batcher = httpBatcher(config, pusher, flushInterval)
+	}
+	return pusher
+}
+
+// httpBatcher returns the batcher of the endpoint. Its batches are posted
+// with an endpoint of their own, closing the sink of one push does not fail
+// the batch of the others.
+func httpBatcher(config Config, pusher *HttpPusher, flushInterval time.Duration) *batch.Batcher {
+	var settings []string
+	for _, name := range []string{"URL", "FORMAT", "HEADERS", "BEARER_TOKEN", "BASIC_USERNAME", "GZIP", "BATCH_SIZE", "FLUSH_INTERVAL"} {
+		settings = append(settings, config("HTTP_"+name))
+	}
+	key := strings.Join(settings, "\n")
+
+	httpBatchersMutex.Lock()
+	defer httpBatchersMutex.Unlock()
+	batcher, ok := httpBatchers[key]
+	if !ok {
+		poster := &HttpPusher{endpoint: newHttpEndpoint(config, "HTTP_"), ndjson: pusher.ndjson, batchSize: pusher.batchSize}
+		batcher = batch.New(pusher.batchSize, flushInterval, poster.post)
+		httpBatchers[key] = batcher
+	}
+	return batcher
+}
+
+func batchSize(value string) int {
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		return defaultBatchSize
+	}
+	return size
+}
+
+// batches splits the items to slices of at most size items.
+func batches(items []json.RawMessage, size int) [][]json.RawMessage {
+	var result [][]json.RawMessage
+	for len(items) > size {
+		result = append(result, items[:size])
+		items = items[size:]
+	}
+	if len(items) > 0 {
+		result = append(result, items)
+	}
+	return result
+}
+
+func (p *HttpPusher) Push(items []json.RawMessage) error {
+	if p.batcher != nil && len(items) > 0 {
+		return p.batcher.Push(items)
+	}
+	return p.post(items)
+}
+
+func (p *HttpPusher) post(items []json.RawMessage) error {
+	for _, part := range batches(items, p.batchSize) {
+		if _, err := p.endpoint.post("", p.body(part), p.contentType()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close aborts a push in progress.
+func (p *HttpPusher) Close() error {
+	p.endpoint.close()
+	return nil
+}
+
+func (p *HttpPusher) body(batch []json.RawMessage) []byte {
+	if !p.ndjson {
+		body, _ := json.Marshal(batch)
+		return body
+	}
+	var body []byte
+	for _, item := range batch {
+		body = append(append(body, item...), '\n')
+	}
+	return body
+}
+
+func (p *HttpPusher) contentType() string {
+	if p.ndjson {
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
@@ -0,0 +1,197 @@
+package pusher
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receivedRequest struct {
+	header http.Header
+	body   string
+}
+
+type fakeCollector struct {
+	sync.Mutex
+	requests []receivedRequest
+	statuses []int
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		reader, _ := gzip.NewReader(strings.NewReader(string(body)))
+		body, _ = ioutil.ReadAll(reader)
+	}
+	c.requests = append(c.requests, receivedRequest{header: r.Header, body: string(body)})
+
+	if len(c.statuses) > 0 {
+		status := c.statuses[0]
+		c.statuses = c.statuses[1:]
+		w.WriteHeader(status)
+	}
+}
+
+var httpItems = []json.RawMessage{
+	json.RawMessage(`{"id":"1"}`),
+	json.RawMessage(`{"id":"2"}`),
+	json.RawMessage(`{"id":"3"}`),
+}
+
+var httpSettings = map[string]string{
+	"HTTP_RETRY_WAIT": "1ms",
+}
+
+func TestEventsPostedInJsonBatches(t *testing.T) {
+	collector := &fakeCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	err := NewHttp(configOf(httpSettings, map[string]string{
+		"HTTP_URL":          server.URL,
+		"HTTP_BATCH_SIZE":   "2",
+		"HTTP_HEADERS":      "X-Source: mailgun; X-Env: test",
+		"HTTP_BEARER_TOKEN": "token",
+	})).Push(httpItems)
+
+	assertNoErrors(t, err)
+	if len(collector.requests) != 2 {
+		t.Fatalf("Two batches expected, got %d", len(collector.requests))
+	}
+	if collector.requests[0].body != `[{"id":"1"},{"id":"2"}]` || collector.requests[1].body != `[{"id":"3"}]` {
+		t.Errorf("Json array batches expected, got %s and %s", collector.requests[0].body, collector.requests[1].body)
+	}
+	header := collector.requests[0].header
+	if header.Get("X-Source") != "mailgun" || header.Get("X-Env") != "test" || header.Get("Authorization") != "Bearer token" {
+		t.Errorf("Custom headers expected. %v", header)
+	}
+	if header.Get("Content-Type") != "application/json" {
+		t.Errorf("Json content type expected.")
+	}
+}
+
+func TestPartialBatchesOfPushesPostedTogether(t *testing.T) {
+	collector := &fakeCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+	config := configOf(httpSettings, map[string]string{
+		"HTTP_URL":            server.URL,
+		"HTTP_BATCH_SIZE":     "3",
+		"HTTP_FLUSH_INTERVAL": "1h",
+	})
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for index, items := range [][]json.RawMessage{httpItems[:1], httpItems[1:]} {
+		wg.Add(1)
+		go func(index int, items []json.RawMessage) {
+			defer wg.Done()
+			errs[index] = NewHttp(config).Push(items)
+		}(index, items)
+	}
+	wg.Wait()
+
+	assertNoErrors(t, errs[0])
+	assertNoErrors(t, errs[1])
+	if len(collector.requests) != 1 || len(collector.requests[0].body) != len(`[{"id":"1"},{"id":"2"},{"id":"3"}]`) {
+		t.Errorf("One full batch expected, got %v", collector.requests)
+	}
+}
+
+func TestPartialBatchPostedAfterFlushInterval(t *testing.T) {
+	collector := &fakeCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+	start := time.Now()
+
+	err := NewHttp(configOf(httpSettings, map[string]string{
+		"HTTP_URL":            server.URL,
+		"HTTP_FLUSH_INTERVAL": "50ms",
+	})).Push(httpItems)
+
+	assertNoErrors(t, err)
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Partial batch expected to wait for the flush interval.")
+	}
+	if len(collector.requests) != 1 || collector.requests[0].body != `[{"id":"1"},{"id":"2"},{"id":"3"}]` {
+		t.Errorf("One batch expected after the interval, got %v", collector.requests)
+	}
+}
+
+func TestEventsPostedAsGzippedNdjsonWithBasicAuth(t *testing.T) {
+	collector := &fakeCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	err := NewHttp(configOf(httpSettings, map[string]string{
+		"HTTP_URL":            server.URL,
+		"HTTP_FORMAT":         "ndjson",
+		"HTTP_GZIP":           "true",
+		"HTTP_BASIC_USERNAME": "user",
+		"HTTP_BASIC_PASSWORD": "secret",
+	})).Push(httpItems)
+
+	assertNoErrors(t, err)
+	request := collector.requests[0]
+	if request.body != "{\"id\":\"1\"}\n{\"id\":\"2\"}\n{\"id\":\"3\"}\n" {
+		t.Errorf("Ndjson body expected, got %s", request.body)
+	}
+	if request.header.Get("Authorization") != "Basic dXNlcjpzZWNyZXQ=" || request.header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("Basic auth and gzip expected. %v", request.header)
+	}
+}
+
+func TestServerErrorAndThrottlingRetried(t *testing.T) {
+	collector := &fakeCollector{statuses: []int{503, 429, 200}}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	err := NewHttp(configOf(httpSettings, map[string]string{"HTTP_URL": server.URL})).Push(httpItems)
+
+	assertNoErrors(t, err)
+	if len(collector.requests) != 3 {
+		t.Errorf("Two retries expected, got %d requests", len(collector.requests))
+	}
+}
+
+func TestClientErrorFailsPush(t *testing.T) {
+	collector := &fakeCollector{statuses: []int{400}}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	err := NewHttp(configOf(httpSettings, map[string]string{"HTTP_URL": server.URL})).Push(httpItems)
+
+	if err == nil || !strings.Contains(err.Error(), "Statuscode was 400") {
+		t.Errorf("Statuscode error expected. %s", err)
+	}
+	if len(collector.requests) != 1 {
+		t.Errorf("No retry expected for client error.")
+	}
+}
+
+func TestExhaustedRetriesFailPush(t *testing.T) {
+	collector := &fakeCollector{statuses: []int{500, 500}}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	err := NewHttp(configOf(httpSettings, map[string]string{"HTTP_URL": server.URL, "HTTP_RETRY_MAX": "1"})).Push(httpItems)
+
+	if err == nil {
+		t.Errorf("Error expected after retries.")
+	}
+}
+
+func httpHandlerWithBody(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
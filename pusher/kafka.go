@@ -0,0 +1,214 @@
+package pusher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"matchwork/mailgun-log-fetcher/event"
+	"strings"
+	"time"
+)
+
+const defaultKafkaTopic = "mailgun"
+const defaultKafkaTimeout = 30 * time.Second
+
+// kafkaBatchTimeout is the wait for more records of a partial batch. A page
+// is produced at once, so there are none to wait for.
+const kafkaBatchTimeout = 10 * time.Millisecond
+
+// newKafkaTransport connects to the brokers, replaced in tests.
+var newKafkaTransport = kafkaTransport
+
+// KafkaPusher produces events to Kafka topics, one record per event. A page
+// is pushed when the partition leaders acknowledged every record batch.
+type KafkaPusher struct {
+	writer    *kafka.Writer
+	transport kafka.RoundTripper
+	topic     string
+	domain    string
+	key       string
+	timeout   time.Duration
+	context   context.Context
+	cancel    context.CancelFunc
+}
+
+func init() {
+	Register("kafka", NewKafka)
+}
+
+func NewKafka(config Config) PusherInterface {
+	key := config("KAFKA_KEY")
+	if key == "" {
+		key = "id"
+	}
+	if key != "id" && key != "recipient" {
+		panic(fmt.Sprintf("Unknown kafka key: %s", key))
+	}
+
+	var acks kafka.RequiredAcks
+	switch config("KAFKA_ACKS") {
+	case "", "all":
+		acks = kafka.RequireAll
+	case "1":
+		acks = kafka.RequireOne
+	default:
+		panic(fmt.Sprintf("Unsupported kafka acks: %s", config("KAFKA_ACKS")))
+	}
+
+	var compression kafka.Compression
+	switch config("KAFKA_COMPRESSION") {
+	case "", "none":
+	case "gzip":
+		compression = kafka.Gzip
+	case "snappy":
+		compression = kafka.Snappy
+	default:
+		panic(fmt.Sprintf("Unsupported kafka compression: %s", config("KAFKA_COMPRESSION")))
+	}
+
+	topic := config("KAFKA_TOPIC")
+	if topic == "" {
+		topic = defaultKafkaTopic
+	}
+
+	var brokers []string
+	for _, broker := range strings.Split(config("KAFKA_BROKERS"), ",") {
+		brokers = append(brokers, strings.TrimSpace(broker))
+	}
+	timeout := durationOr(config("KAFKA_TIMEOUT"), defaultKafkaTimeout)
+	transport := newKafkaTransport(config, timeout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	connectCtx, connectCancel := context.WithTimeout(ctx, timeout)
+	defer connectCancel()
+	client := &kafka.Client{Addr: kafka.TCP(brokers...), Transport: transport, Timeout: timeout}
+	if _, err := client.ApiVersions(connectCtx, &kafka.ApiVersionsRequest{Addr: client.Addr}); err != nil {
+		cancel()
+		closeIdleConnections(transport)
+		panic(fmt.Sprintf("Failed to connect to kafka. %s", err))
+	}
+
+	return &KafkaPusher{
+		writer: &kafka.Writer{
+			Addr:         client.Addr,
+			Balancer:     kafkaBalancer(),
+			MaxAttempts:  1,
+			BatchSize:    batchSize(config("KAFKA_BATCH_SIZE")),
+			BatchTimeout: kafkaBatchTimeout,
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+			RequiredAcks: acks,
+			Compression:  compression,
+			Transport:    transport,
+		},
+		transport: transport,
+		topic:     topic,
+		domain:    config("MAIL_DOMAIN"),
+		key:       key,
+		timeout:   timeout,
+		context:   ctx,
+		cancel:    cancel,
+	}
+}
+
+// kafkaTransport connects to the brokers, with TLS and SASL when configured.
+func kafkaTransport(config Config, timeout time.Duration) kafka.RoundTripper {
+	transport := &kafka.Transport{
+		ClientID:    "mglogfetch",
+		DialTimeout: timeout,
+	}
+	if config("KAFKA_TLS") == "true" {
+		transport.TLS = tlsConfig(config, "KAFKA_TLS_")
+	}
+	if mechanism := config("KAFKA_SASL_MECHANISM"); mechanism != "" {
+		transport.SASL = kafkaMechanism(mechanism, config("KAFKA_SASL_USERNAME"), config("KAFKA_SASL_PASSWORD"))
+	}
+	return transport
+}
+
+func kafkaMechanism(mechanism string, username string, password string) sasl.Mechanism {
+	var algorithm scram.Algorithm
+	switch mechanism {
+	case "PLAIN":
+		return plain.Mechanism{Username: username, Password: password}
+	case "SCRAM-SHA-256":
+		algorithm = scram.SHA256
+	case "SCRAM-SHA-512":
+		algorithm = scram.SHA512
+	default:
+		panic(fmt.Sprintf("Unsupported kafka sasl mechanism: %s", mechanism))
+	}
+	scramMechanism, err := scram.Mechanism(algorithm, username, password)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid kafka sasl credentials. %s", err))
+	}
+	return scramMechanism
+}
+
+// kafkaBalancer partitions records by key like the Java producer does, and
+// spreads records without key round-robin over the partitions.
+func kafkaBalancer() kafka.Balancer {
+	roundRobin := &kafka.RoundRobin{}
+	return kafka.BalancerFunc(func(message kafka.Message, partitions ...int) int {
+		if message.Key == nil {
+			return roundRobin.Balance(message, partitions...)
+		}
+		return kafka.Murmur2Balancer{}.Balance(message, partitions...)
+	})
+}
+
+func (p *KafkaPusher) Push(items []json.RawMessage) error {
+	defer p.Close()
+
+	messages := make([]kafka.Message, len(items))
+	for index, item := range items {
+		e := event.Parse(item)
+		key := e.ID
+		if p.key == "recipient" {
+			key = e.Recipient
+		}
+		messages[index] = kafka.Message{
+			Topic: expandPattern(p.topic, p.domain, e),
+			Value: item,
+			Time:  e.Time(),
+		}
+		if key != "" {
+			messages[index].Key = []byte(key)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(p.context, p.timeout)
+	defer cancel()
+	err := p.writer.WriteMessages(ctx, messages...)
+
+	var writeErrors kafka.WriteErrors
+	if errors.As(err, &writeErrors) {
+		for _, writeError := range writeErrors {
+			if writeError != nil {
+				return fmt.Errorf("Kafka rejected the events. %s", writeError)
+			}
+		}
+	}
+	return err
+}
+
+// Close aborts a push in progress.
+func (p *KafkaPusher) Close() error {
+	p.cancel()
+	err := p.writer.Close()
+	closeIdleConnections(p.transport)
+	return err
+}
+
+// closeIdleConnections closes the connections to the brokers, a transport
+// is not shared between pushes.
+func closeIdleConnections(transport kafka.RoundTripper) {
+	if closer, ok := transport.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,203 @@
+package pusher
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+	"github.com/stretchr/testify/assert"
+)
+
+type producedRecord struct {
+	topic     string
+	partition int32
+	key       []byte
+	value     string
+	timestamp int64
+}
+
+// fakeBroker is a single Kafka broker leading every partition of its topics,
+// used as the transport of the sink. Produce requests are answered with
+// errorCode.
+type fakeBroker struct {
+	sync.Mutex
+	partitions int
+	errorCode  int16
+	acks       []int16
+	attributes []protocol.Attributes
+	records    []producedRecord
+}
+
+func startFakeBroker(t *testing.T, partitions int) *fakeBroker {
+	broker := &fakeBroker{partitions: partitions}
+	newKafkaTransport = func(config Config, timeout time.Duration) kafka.RoundTripper {
+		return broker
+	}
+	t.Cleanup(func() {
+		newKafkaTransport = kafkaTransport
+	})
+	return broker
+}
+
+func (b *fakeBroker) RoundTrip(ctx context.Context, addr net.Addr, request kafka.Request) (kafka.Response, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	switch request := request.(type) {
+	case *apiversions.Request:
+		return &apiversions.Response{}, nil
+	case *metadata.Request:
+		response := &metadata.Response{Brokers: []metadata.ResponseBroker{{NodeID: 0, Host: "localhost", Port: 9092}}}
+		for _, name := range request.TopicNames {
+			topic := metadata.ResponseTopic{Name: name}
+			for partition := 0; partition < b.partitions; partition++ {
+				topic.Partitions = append(topic.Partitions, metadata.ResponsePartition{PartitionIndex: int32(partition)})
+			}
+			response.Topics = append(response.Topics, topic)
+		}
+		return response, nil
+	case *produce.Request:
+		b.acks = append(b.acks, request.Acks)
+		response := &produce.Response{}
+		for _, topic := range request.Topics {
+			responseTopic := produce.ResponseTopic{Topic: topic.Topic}
+			for _, partition := range topic.Partitions {
+				b.attributes = append(b.attributes, partition.RecordSet.Attributes)
+				for {
+					record, err := partition.RecordSet.Records.ReadRecord()
+					if err == io.EOF {
+						break
+					}
+					key, _ := protocol.ReadAll(record.Key)
+					value, _ := protocol.ReadAll(record.Value)
+					b.records = append(b.records, producedRecord{
+						topic:     topic.Topic,
+						partition: partition.Partition,
+						key:       key,
+						value:     string(value),
+						timestamp: record.Time.UnixNano() / int64(time.Millisecond),
+					})
+				}
+				responseTopic.Partitions = append(responseTopic.Partitions, produce.ResponsePartition{Partition: partition.Partition, ErrorCode: b.errorCode})
+			}
+			response.Topics = append(response.Topics, responseTopic)
+		}
+		return response, nil
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+// recordOf is the record of the topic, the records of a page are produced
+// to their topics concurrently.
+func (b *fakeBroker) recordOf(topic string) producedRecord {
+	for _, record := range b.records {
+		if record.topic == topic {
+			return record
+		}
+	}
+	return producedRecord{}
+}
+
+var kafkaItems = []json.RawMessage{
+	json.RawMessage(`{"event":"delivered","id":"21","recipient":"a@example.com","timestamp":1636532734.023108}`),
+	json.RawMessage(`{"event":"failed","id":"foobar","recipient":"b@example.com","timestamp":1636532735}`),
+}
+
+var kafkaSettings = map[string]string{
+	"MAIL_DOMAIN":   "mg.example.com",
+	"KAFKA_BROKERS": "kafka-1:9092, kafka-2:9092",
+}
+
+func TestEventsProducedToTemplatedTopics(t *testing.T) {
+	broker := startFakeBroker(t, 4)
+
+	err := NewKafka(configOf(kafkaSettings, map[string]string{"KAFKA_TOPIC": "mailgun.{domain}.{event}"})).Push(kafkaItems)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int16{-1, -1}, broker.acks)
+	assert.Equal(t, producedRecord{
+		topic:     "mailgun.mg.example.com.delivered",
+		partition: int32(kafka.Murmur2Balancer{}.Balance(kafka.Message{Key: []byte("21")}, 0, 1, 2, 3)),
+		key:       []byte("21"),
+		value:     string(kafkaItems[0]),
+		timestamp: 1636532734023,
+	}, broker.recordOf("mailgun.mg.example.com.delivered"))
+	assert.Equal(t, []byte("foobar"), broker.recordOf("mailgun.mg.example.com.failed").key)
+}
+
+func TestRecipientKeyCompressedBatches(t *testing.T) {
+	broker := startFakeBroker(t, 1)
+
+	err := NewKafka(configOf(kafkaSettings, map[string]string{
+		"KAFKA_KEY":         "recipient",
+		"KAFKA_COMPRESSION": "gzip",
+		"KAFKA_ACKS":        "1",
+		"KAFKA_BATCH_SIZE":  "1",
+	})).Push(kafkaItems)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int16{1, 1}, broker.acks)
+	assert.Equal(t, protocol.Attributes(kafka.Gzip), broker.attributes[0]&7)
+	assert.Equal(t, "mailgun", broker.records[0].topic)
+	assert.Equal(t, []byte("a@example.com"), broker.records[0].key)
+	assert.Equal(t, []byte("b@example.com"), broker.records[1].key)
+}
+
+func TestEventsWithoutIdSpreadRoundRobin(t *testing.T) {
+	broker := startFakeBroker(t, 2)
+	items := []json.RawMessage{json.RawMessage(`{"event":"delivered"}`), json.RawMessage(`{"event":"delivered"}`)}
+
+	err := NewKafka(configOf(kafkaSettings)).Push(items)
+
+	assert.Nil(t, err)
+	if len(broker.records) != 2 || broker.records[0].partition == broker.records[1].partition {
+		t.Errorf("Records expected on both partitions. %v", broker.records)
+	}
+	for _, record := range broker.records {
+		assert.Nil(t, record.key)
+	}
+}
+
+func TestRejectedProduceFails(t *testing.T) {
+	broker := startFakeBroker(t, 1)
+	broker.errorCode = 6
+
+	err := NewKafka(configOf(kafkaSettings)).Push(kafkaItems)
+
+	if err == nil || !strings.Contains(err.Error(), "Kafka rejected the events.") {
+		t.Errorf("Rejected events expected. %s", err)
+	}
+}
+
+func TestUnreachableBrokersPanic(t *testing.T) {
+	defer func() {
+		f := recover()
+		if f == nil || !strings.Contains(f.(string), "Failed to connect to kafka.") {
+			t.Errorf("Connection panic expected. %s", f)
+		}
+	}()
+
+	NewKafka(configOf(map[string]string{"KAFKA_BROKERS": "localhost:1", "KAFKA_TIMEOUT": "1s"}))
+}
+
+func TestUnknownKafkaKeyPanics(t *testing.T) {
+	assert.PanicsWithValue(t, "Unknown kafka key: subject", func() {
+		NewKafka(configOf(map[string]string{"KAFKA_KEY": "subject"}))
+	})
+}
+
+func TestUnsupportedSaslMechanismPanics(t *testing.T) {
+	assert.PanicsWithValue(t, "Unsupported kafka sasl mechanism: GSSAPI", func() {
+		NewKafka(configOf(kafkaSettings, map[string]string{"KAFKA_SASL_MECHANISM": "GSSAPI"}))
+	})
+}
@@ -0,0 +1,212 @@
+package pusher
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"github.com/golang/snappy"
+	"log"
+	"matchwork/mailgun-log-fetcher/event"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const lokiPushPath = "/loki/api/v1/push"
+const defaultLokiLabels = "event=event,severity=severity"
+
+// lokiRejectedPages counts the pages with entries Loki refused as out of order.
+var lokiRejectedPages = expvar.NewInt("loki_rejected_pages")
+
+type lokiLabel struct {
+	name string
+	path string
+}
+
+type lokiEntry struct {
+	time time.Time
+	line string
+}
+
+type lokiStream struct {
+	labels  map[string]string
+	entries []lokiEntry
+}
+
+// LokiPusher sends events to the Loki push API in streams labelled by event
+// fields, with the time of the Mailgun event as the line timestamp.
+type LokiPusher struct {
+	endpoint     *httpEndpoint
+	json         bool
+	labels       []lokiLabel
+	staticLabels map[string]string
+	batchSize    int
+}
+
+func init() {
+	Register("loki", NewLoki)
+}
+
+func NewLoki(config Config) PusherInterface {
+	format := config("LOKI_FORMAT")
+	if format != "" && format != "protobuf" && format != "json" {
+		panic(fmt.Sprintf("Unknown loki format: %s", format))
+	}
+	labels := config("LOKI_LABELS")
+	if labels == "" {
+		labels = defaultLokiLabels
+	}
+
+	return &LokiPusher{
+		endpoint:     newHttpEndpoint(config, "LOKI_"),
+		json:         format == "json",
+		labels:       parseLokiLabels(labels),
+		staticLabels: map[string]string{"job": "mailgun", "domain": config("MAIL_DOMAIN")},
+		batchSize:    batchSize(config("LOKI_BATCH_SIZE")),
+	}
+}
+
+// parseLokiLabels reads labels in the form "name=field.path,other=field".
+func parseLokiLabels(labels string) []lokiLabel {
+	var parsed []lokiLabel
+	for _, label := range strings.Split(labels, ",") {
+		parts := strings.SplitN(strings.TrimSpace(label), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			panic(fmt.Sprintf("Invalid loki label: %s", label))
+		}
+		parsed = append(parsed, lokiLabel{name: parts[0], path: parts[1]})
+	}
+	return parsed
+}
+
+func (p *LokiPusher) Push(items []json.RawMessage) error {
+	for _, batch := range batches(items, p.batchSize) {
+		if err := p.pushStreams(p.streams(batch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close aborts a push in progress.
+func (p *LokiPusher) Close() error {
+	p.endpoint.close()
+	return nil
+}
+
+func (p *LokiPusher) pushStreams(streams []*lokiStream) error {
+	body, contentType := p.encodeProtobuf(streams), "application/x-protobuf"
+	if p.json {
+		body, contentType = p.encodeJson(streams), "application/json"
+	}
+
+	_, err := p.endpoint.post(lokiPushPath, body, contentType)
+	if isOutOfOrder(err) {
+		// Loki keeps the accepted entries of the request, the rejected ones
+		// would never be accepted, so the page is not retried.
+		log.Printf("Loki rejected entries. %s", err)
+		lokiRejectedPages.Add(1)
+		return nil
+	}
+	return err
+}
+
+func isOutOfOrder(err error) bool {
+	statusErr, ok := err.(*statusError)
+	if !ok || statusErr.statusCode != 400 {
+		return false
+	}
+	body := string(statusErr.body)
+	return strings.Contains(body, "out of order") || strings.Contains(body, "too far behind")
+}
+
+// streams groups the items by their labels, entries of a stream are sorted by
+// time as Loki requires.
+func (p *LokiPusher) streams(items []json.RawMessage) []*lokiStream {
+	byLabels := map[string]*lokiStream{}
+	var streams []*lokiStream
+	for _, item := range items {
+		labels := p.labelsOf(event.Decode(item))
+		key := formatLabels(labels)
+		stream, ok := byLabels[key]
+		if !ok {
+			stream = &lokiStream{labels: labels}
+			byLabels[key] = stream
+			streams = append(streams, stream)
+		}
+		stream.entries = append(stream.entries, lokiEntry{time: event.Parse(item).Time(), line: string(item)})
+	}
+
+	for _, stream := range streams {
+		sort.SliceStable(stream.entries, func(i, j int) bool {
+			return stream.entries[i].time.Before(stream.entries[j].time)
+		})
+	}
+	return streams
+}
+
+func (p *LokiPusher) labelsOf(document map[string]interface{}) map[string]string {
+	labels := map[string]string{}
+	for name, value := range p.staticLabels {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	for _, label := range p.labels {
+		if value := event.LookupString(document, label.path); value != "" {
+			labels[label.name] = value
+		}
+	}
+	return labels
+}
+
+// formatLabels writes labels in the Prometheus form {job="mailgun"}.
+func formatLabels(labels map[string]string) string {
+	var names []string
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, name := range names {
+		pairs = append(pairs, name+"="+strconv.Quote(labels[name]))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func (p *LokiPusher) encodeJson(streams []*lokiStream) []byte {
+	type jsonStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	var request struct {
+		Streams []jsonStream `json:"streams"`
+	}
+	for _, stream := range streams {
+		encoded := jsonStream{Stream: stream.labels}
+		for _, entry := range stream.entries {
+			encoded.Values = append(encoded.Values, [2]string{strconv.FormatInt(entry.time.UnixNano(), 10), entry.line})
+		}
+		request.Streams = append(request.Streams, encoded)
+	}
+	body, _ := json.Marshal(request)
+	return body
+}
+
+// encodeProtobuf writes a snappy compressed logproto.PushRequest.
+func (p *LokiPusher) encodeProtobuf(streams []*lokiStream) []byte {
+	var request protoMessage
+	for _, stream := range streams {
+		encoded := protoMessage{}.String(1, formatLabels(stream.labels))
+		for _, entry := range stream.entries {
+			timestamp := protoMessage{}.
+				Varint(1, uint64(entry.time.Unix())).
+				Varint(2, uint64(entry.time.Nanosecond()))
+			encoded = encoded.Message(2, protoMessage{}.Message(1, timestamp).String(2, entry.line))
+		}
+		request = request.Message(1, encoded)
+	}
+	return snappy.Encode(nil, request)
+}
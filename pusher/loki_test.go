@@ -0,0 +1,97 @@
+package pusher
+
+import (
+	"encoding/json"
+	"github.com/golang/snappy"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var lokiItems = []json.RawMessage{
+	json.RawMessage(`{"event":"delivered","timestamp":1636532734.023108}`),
+	json.RawMessage(`{"event":"failed","severity":"permanent","timestamp":1636532241.823582}`),
+	json.RawMessage(`{"event":"delivered","timestamp":1636532679.442269}`),
+}
+
+var lokiSettings = map[string]string{
+	"LOKI_RETRY_WAIT": "1ms",
+	"MAIL_DOMAIN":     "mg.example.com",
+}
+
+func TestEventsPushedToLokiAsJsonStreams(t *testing.T) {
+	collector := &fakeCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	err := NewLoki(configOf(lokiSettings, map[string]string{"LOKI_URL": server.URL, "LOKI_FORMAT": "json"})).Push(lokiItems)
+
+	assertNoErrors(t, err)
+	expected := `{"streams":[` +
+		`{"stream":{"domain":"mg.example.com","event":"delivered","job":"mailgun"},"values":[` +
+		`["1636532679442269000","{\"event\":\"delivered\",\"timestamp\":1636532679.442269}"],` +
+		`["1636532734023108000","{\"event\":\"delivered\",\"timestamp\":1636532734.023108}"]]},` +
+		`{"stream":{"domain":"mg.example.com","event":"failed","job":"mailgun","severity":"permanent"},"values":[` +
+		`["1636532241823582000","{\"event\":\"failed\",\"severity\":\"permanent\",\"timestamp\":1636532241.823582}"]]}]}`
+	if collector.requests[0].body != expected {
+		t.Errorf("Failed asserting %s is equal with %s", collector.requests[0].body, expected)
+	}
+}
+
+func TestEventsPushedToLokiAsSnappyProtobuf(t *testing.T) {
+	collector := &fakeCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	err := NewLoki(configOf(lokiSettings, map[string]string{"LOKI_URL": server.URL, "LOKI_LABELS": "type=event"})).Push(lokiItems[:1])
+
+	assertNoErrors(t, err)
+	request := collector.requests[0]
+	if request.header.Get("Content-Type") != "application/x-protobuf" {
+		t.Errorf("Protobuf content type expected.")
+	}
+	decoded, err := snappy.Decode(nil, []byte(request.body))
+	if err != nil {
+		t.Fatalf("Snappy body expected. %s", err)
+	}
+
+	labels := `{domain="mg.example.com", job="mailgun", type="delivered"}`
+	line := string(lokiItems[0])
+	timestamp := protoMessage{}.Varint(1, 1636532734).Varint(2, 23108000)
+	entry := protoMessage{}.Message(1, timestamp).String(2, line)
+	expected := protoMessage{}.Message(1, protoMessage{}.String(1, labels).Message(2, entry))
+	if string(decoded) != string(expected) {
+		t.Errorf("Failed asserting push request %q", decoded)
+	}
+}
+
+func TestOutOfOrderRejectionNotRetried(t *testing.T) {
+	collector := &fakeCollector{statuses: []int{400}}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+	rejectedBefore := lokiRejectedPages.Value()
+
+	err := NewLoki(configOf(lokiSettings, map[string]string{"LOKI_URL": server.URL})).Push(lokiItems)
+	if err == nil {
+		t.Errorf("Bad request without out of order message expected to fail.")
+	}
+
+	server.Config.Handler = httpHandlerWithBody(400, "entry with timestamp 2021-11-10 08:17:21 ignored, reason: 'entry out of order'")
+	err = NewLoki(configOf(lokiSettings, map[string]string{"LOKI_URL": server.URL})).Push(lokiItems)
+
+	assertNoErrors(t, err)
+	if lokiRejectedPages.Value() != rejectedBefore+1 {
+		t.Errorf("Rejected page expected to be counted.")
+	}
+}
+
+func TestInvalidLokiLabelFailed(t *testing.T) {
+	defer func() {
+		f := recover()
+		if f == nil || !strings.Contains(f.(string), "Invalid loki label") {
+			t.Errorf("Label panic expected. %s", f)
+		}
+	}()
+
+	NewLoki(configOf(lokiSettings, map[string]string{"LOKI_URL": "http://loki", "LOKI_LABELS": "event"}))
+}
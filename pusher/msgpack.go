@@ -0,0 +1,258 @@
+package pusher
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// msgpack encodes MessagePack values, enough for JSON documents and the
+// EventTime extension of the Fluent forward protocol.
+type msgpack []byte
+
+func (m msgpack) bigEndian(value uint64, size int) msgpack {
+	buffer := make([]byte, 8)
+	binary.BigEndian.PutUint64(buffer, value)
+	return append(m, buffer[8-size:]...)
+}
+
+func (m msgpack) Nil() msgpack {
+	return append(m, 0xc0)
+}
+
+func (m msgpack) Bool(value bool) msgpack {
+	if value {
+		return append(m, 0xc3)
+	}
+	return append(m, 0xc2)
+}
+
+func (m msgpack) Int(value int64) msgpack {
+	switch {
+	case value >= 0 && value <= 0x7f, value < 0 && value >= -32:
+		return append(m, byte(value))
+	case value >= 0:
+		return append(m, 0xcf).bigEndian(uint64(value), 8)
+	}
+	return append(m, 0xd3).bigEndian(uint64(value), 8)
+}
+
+func (m msgpack) Float(value float64) msgpack {
+	return append(m, 0xcb).bigEndian(math.Float64bits(value), 8)
+}
+
+func (m msgpack) String(value string) msgpack {
+	length := len(value)
+	switch {
+	case length < 32:
+		m = append(m, 0xa0|byte(length))
+	case length <= math.MaxUint8:
+		m = append(m, 0xd9, byte(length))
+	case length <= math.MaxUint16:
+		m = append(m, 0xda).bigEndian(uint64(length), 2)
+	default:
+		m = append(m, 0xdb).bigEndian(uint64(length), 4)
+	}
+	return append(m, value...)
+}
+
+func (m msgpack) Binary(value []byte) msgpack {
+	length := len(value)
+	switch {
+	case length <= math.MaxUint8:
+		m = append(m, 0xc4, byte(length))
+	case length <= math.MaxUint16:
+		m = append(m, 0xc5).bigEndian(uint64(length), 2)
+	default:
+		m = append(m, 0xc6).bigEndian(uint64(length), 4)
+	}
+	return append(m, value...)
+}
+
+func (m msgpack) Array(length int) msgpack {
+	switch {
+	case length < 16:
+		return append(m, 0x90|byte(length))
+	case length <= math.MaxUint16:
+		return append(m, 0xdc).bigEndian(uint64(length), 2)
+	}
+	return append(m, 0xdd).bigEndian(uint64(length), 4)
+}
+
+func (m msgpack) Map(length int) msgpack {
+	switch {
+	case length < 16:
+		return append(m, 0x80|byte(length))
+	case length <= math.MaxUint16:
+		return append(m, 0xde).bigEndian(uint64(length), 2)
+	}
+	return append(m, 0xdf).bigEndian(uint64(length), 4)
+}
+
+// EventTime is the ext type 0 of Fluentd, a time with nanoseconds.
+func (m msgpack) EventTime(value time.Time) msgpack {
+	m = append(m, 0xd7, 0x00)
+	return m.bigEndian(uint64(value.Unix()), 4).bigEndian(uint64(value.Nanosecond()), 4)
+}
+
+// Value encodes a decoded JSON value, map keys in sorted order.
+func (m msgpack) Value(value interface{}) msgpack {
+	switch typed := value.(type) {
+	case nil:
+		return m.Nil()
+	case bool:
+		return m.Bool(typed)
+	case string:
+		return m.String(typed)
+	case json.Number:
+		if integer, err := typed.Int64(); err == nil {
+			return m.Int(integer)
+		}
+		float, _ := typed.Float64()
+		return m.Float(float)
+	case float64:
+		return m.Float(typed)
+	case []interface{}:
+		m = m.Array(len(typed))
+		for _, element := range typed {
+			m = m.Value(element)
+		}
+		return m
+	case map[string]interface{}:
+		keys := make([]string, 0, len(typed))
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		m = m.Map(len(keys))
+		for _, key := range keys {
+			m = m.String(key).Value(typed[key])
+		}
+		return m
+	}
+	return m.String(fmt.Sprint(value))
+}
+
+// readMsgpack decodes one MessagePack value. Maps are decoded with string
+// keys, strings and binaries as string, extension types are skipped as nil.
+func readMsgpack(reader *bufio.Reader) (interface{}, error) {
+	marker, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case marker <= 0x7f:
+		return int64(marker), nil
+	case marker >= 0xe0:
+		return int64(int8(marker)), nil
+	case marker&0xe0 == 0xa0:
+		return readMsgpackString(reader, int(marker&0x1f))
+	case marker&0xf0 == 0x90:
+		return readMsgpackArray(reader, int(marker&0x0f))
+	case marker&0xf0 == 0x80:
+		return readMsgpackMap(reader, int(marker&0x0f))
+	}
+
+	switch marker {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xd9:
+		return readMsgpackSized(reader, 1, readMsgpackString)
+	case 0xc5, 0xda:
+		return readMsgpackSized(reader, 2, readMsgpackString)
+	case 0xc6, 0xdb:
+		return readMsgpackSized(reader, 4, readMsgpackString)
+	case 0xdc:
+		return readMsgpackSized(reader, 2, readMsgpackArray)
+	case 0xdd:
+		return readMsgpackSized(reader, 4, readMsgpackArray)
+	case 0xde:
+		return readMsgpackSized(reader, 2, readMsgpackMap)
+	case 0xdf:
+		return readMsgpackSized(reader, 4, readMsgpackMap)
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		value, err := readBigEndian(reader, 1<<(marker-0xcc))
+		return int64(value), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (marker - 0xd0)
+		value, err := readBigEndian(reader, size)
+		shift := 64 - 8*size
+		return int64(value<<shift) >> shift, err
+	case 0xca:
+		value, err := readBigEndian(reader, 4)
+		return float64(math.Float32frombits(uint32(value))), err
+	case 0xcb:
+		value, err := readBigEndian(reader, 8)
+		return math.Float64frombits(value), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		_, err := reader.Discard(1 + 1<<(marker-0xd4))
+		return nil, err
+	case 0xc7, 0xc8, 0xc9:
+		size, err := readBigEndian(reader, 1<<(marker-0xc7))
+		if err == nil {
+			_, err = reader.Discard(1 + int(size))
+		}
+		return nil, err
+	}
+	return nil, fmt.Errorf("Unsupported msgpack type 0x%x", marker)
+}
+
+func readBigEndian(reader *bufio.Reader, size int) (uint64, error) {
+	buffer := make([]byte, 8)
+	if _, err := io.ReadFull(reader, buffer[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buffer), nil
+}
+
+func readMsgpackSized(reader *bufio.Reader, size int, read func(*bufio.Reader, int) (interface{}, error)) (interface{}, error) {
+	length, err := readBigEndian(reader, size)
+	if err != nil {
+		return nil, err
+	}
+	return read(reader, int(length))
+}
+
+func readMsgpackString(reader *bufio.Reader, length int) (interface{}, error) {
+	buffer := make([]byte, length)
+	_, err := io.ReadFull(reader, buffer)
+	return string(buffer), err
+}
+
+func readMsgpackArray(reader *bufio.Reader, length int) (interface{}, error) {
+	array := make([]interface{}, length)
+	for index := range array {
+		value, err := readMsgpack(reader)
+		if err != nil {
+			return nil, err
+		}
+		array[index] = value
+	}
+	return array, nil
+}
+
+func readMsgpackMap(reader *bufio.Reader, length int) (interface{}, error) {
+	object := map[string]interface{}{}
+	for index := 0; index < length; index++ {
+		key, err := readMsgpack(reader)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readMsgpack(reader)
+		if err != nil {
+			return nil, err
+		}
+		object[fmt.Sprint(key)] = value
+	}
+	return object, nil
+}
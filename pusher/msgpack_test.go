@@ -0,0 +1,54 @@
+package pusher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMsgpackValuesEncoded(t *testing.T) {
+	cases := []struct {
+		encoded  msgpack
+		expected []byte
+	}{
+		{msgpack{}.Nil(), []byte{0xc0}},
+		{msgpack{}.Bool(true), []byte{0xc3}},
+		{msgpack{}.Int(5), []byte{0x05}},
+		{msgpack{}.Int(-1), []byte{0xff}},
+		{msgpack{}.Int(300), []byte{0xcf, 0, 0, 0, 0, 0, 0, 0x01, 0x2c}},
+		{msgpack{}.String("tag"), []byte{0xa3, 't', 'a', 'g'}},
+		{msgpack{}.String(strings.Repeat("a", 40))[:2], []byte{0xd9, 40}},
+		{msgpack{}.Binary([]byte{1, 2}), []byte{0xc4, 0x02, 1, 2}},
+		{msgpack{}.Array(2), []byte{0x92}},
+		{msgpack{}.Map(1), []byte{0x81}},
+		{msgpack{}.Float(1), []byte{0xcb, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0}},
+		{msgpack{}.EventTime(time.Unix(1636532734, 23108000)), []byte{0xd7, 0x00, 0x61, 0x8b, 0x81, 0xfe, 0x01, 0x60, 0x99, 0xa0}},
+	}
+
+	for _, c := range cases {
+		if !bytes.Equal(c.encoded, c.expected) {
+			t.Errorf("Failed asserting %x is equal with %x", []byte(c.encoded), c.expected)
+		}
+	}
+}
+
+func TestMsgpackDocumentRoundTrip(t *testing.T) {
+	var document map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(`{"a":[1,-100,1.5,"x",null,true],"b":{"c":"` + strings.Repeat("d", 300) + `"}}`))
+	decoder.UseNumber()
+	decoder.Decode(&document)
+
+	decoded, err := readMsgpack(bufio.NewReader(bytes.NewReader(msgpack{}.Value(document))))
+
+	expected := map[string]interface{}{
+		"a": []interface{}{int64(1), int64(-100), 1.5, "x", nil, true},
+		"b": map[string]interface{}{"c": strings.Repeat("d", 300)},
+	}
+	if err != nil || !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Failed asserting %v is equal with %v. %s", decoded, expected, err)
+	}
+}
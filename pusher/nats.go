@@ -0,0 +1,131 @@
+package pusher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"matchwork/mailgun-log-fetcher/event"
+	"time"
+)
+
+const defaultNatsSubject = "mailgun.{domain}.{event}"
+const defaultNatsAckTimeout = 30 * time.Second
+
+// NatsPusher publishes every event to a NATS subject with the event ID as
+// Nats-Msg-Id, so JetStream drops the events of a page published again.
+// With JetStream a page is pushed when every event was acknowledged by its
+// stream, without it when the server answered a ping after the events.
+type NatsPusher struct {
+	connection *nats.Conn
+	subject    string
+	domain     string
+	jetStream  bool
+	ackTimeout time.Duration
+}
+
+func init() {
+	Register("nats", NewNats)
+}
+
+func NewNats(config Config) PusherInterface {
+	network, address := parseRemoteHost(config("NATS_URL"))
+	if network != "nats" && network != "tls" {
+		panic(fmt.Sprintf("Unsupported nats url scheme: %s", network))
+	}
+
+	subject := config("NATS_SUBJECT")
+	if subject == "" {
+		subject = defaultNatsSubject
+	}
+	ackTimeout := durationOr(config("NATS_ACK_TIMEOUT"), defaultNatsAckTimeout)
+
+	// Servers requiring TLS are upgraded to it with nats:// urls too, with
+	// the NATS_TLS_ settings.
+	clientConfig := tlsConfig(config, "NATS_TLS_")
+	options := []nats.Option{
+		nats.Name("mglogfetch"),
+		nats.Timeout(ackTimeout),
+		func(o *nats.Options) error {
+			o.TLSConfig = clientConfig
+			return nil
+		},
+	}
+	if user := config("NATS_USER"); user != "" {
+		options = append(options, nats.UserInfo(user, config("NATS_PASSWORD")))
+	}
+	if token := config("NATS_TOKEN"); token != "" {
+		options = append(options, nats.Token(token))
+	}
+
+	connection, err := nats.Connect(network+"://"+address, options...)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to connect to nats. %s", err))
+	}
+	return &NatsPusher{
+		connection: connection,
+		subject:    subject,
+		domain:     config("MAIL_DOMAIN"),
+		jetStream:  config("NATS_JETSTREAM") != "false",
+		ackTimeout: ackTimeout,
+	}
+}
+
+func (p *NatsPusher) Push(items []json.RawMessage) error {
+	defer p.connection.Close()
+
+	if !p.jetStream {
+		for _, item := range items {
+			if err := p.connection.PublishMsg(p.message(item)); err != nil {
+				return err
+			}
+		}
+		return p.connection.FlushTimeout(p.ackTimeout)
+	}
+
+	jetStream, err := p.connection.JetStream()
+	if err != nil {
+		return err
+	}
+	acks := make([]nats.PubAckFuture, 0, len(items))
+	for _, item := range items {
+		ack, err := jetStream.PublishMsgAsync(p.message(item))
+		if err != nil {
+			return err
+		}
+		acks = append(acks, ack)
+	}
+
+	timeout := time.After(p.ackTimeout)
+	for _, ack := range acks {
+		select {
+		case <-ack.Ok():
+		case err := <-ack.Err():
+			if errors.Is(err, nats.ErrNoStreamResponse) || errors.Is(err, nats.ErrNoResponders) {
+				return errors.New("No JetStream stream listens on the subject.")
+			}
+			return fmt.Errorf("JetStream rejected the event. %s", err)
+		case <-timeout:
+			return fmt.Errorf("No JetStream ack after %s.", p.ackTimeout)
+		}
+	}
+	return nil
+}
+
+// Close aborts a push in progress.
+func (p *NatsPusher) Close() error {
+	p.connection.Close()
+	return nil
+}
+
+// message is the event on its subject, with its ID as Nats-Msg-Id. Events
+// without ID are not deduplicated.
+func (p *NatsPusher) message(item json.RawMessage) *nats.Msg {
+	e := event.Parse(item)
+	message := nats.NewMsg(expandPattern(p.subject, p.domain, e))
+	message.Data = item
+	if e.ID != "" {
+		message.Header.Set(nats.MsgIdHdr, e.ID)
+	}
+	return message
+}
@@ -0,0 +1,151 @@
+package pusher
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+// startNatsServer runs a nats-server with JetStream for the test.
+func startNatsServer(t *testing.T, options *server.Options) *server.Server {
+	options.Host = "127.0.0.1"
+	options.Port = -1
+	options.JetStream = true
+	options.StoreDir = t.TempDir()
+	options.NoLog = true
+	options.NoSigs = true
+	natsServer, err := server.NewServer(options)
+	if err != nil {
+		t.Fatalf("Failed to create nats server. %s", err)
+	}
+	go natsServer.Start()
+	if !natsServer.ReadyForConnections(5 * time.Second) {
+		t.Fatalf("Nats server not ready.")
+	}
+	t.Cleanup(natsServer.Shutdown)
+	return natsServer
+}
+
+// addStream creates the stream MAILGUN on the subjects of the events.
+func addStream(t *testing.T, natsServer *server.Server, stream *nats.StreamConfig) nats.JetStreamContext {
+	connection, err := nats.Connect(natsServer.ClientURL())
+	if err != nil {
+		t.Fatalf("Failed to connect to nats server. %s", err)
+	}
+	t.Cleanup(connection.Close)
+	jetStream, _ := connection.JetStream()
+	stream.Name = "MAILGUN"
+	stream.Subjects = []string{"mailgun.>"}
+	if _, err := jetStream.AddStream(stream); err != nil {
+		t.Fatalf("Failed to add stream. %s", err)
+	}
+	return jetStream
+}
+
+func natsSettings(natsServer *server.Server) map[string]string {
+	return map[string]string{
+		"NATS_URL":    natsServer.ClientURL(),
+		"MAIL_DOMAIN": "mg.example.com",
+	}
+}
+
+var natsItems = []json.RawMessage{
+	json.RawMessage(`{"event":"delivered","id":"1"}`),
+	json.RawMessage(`{"event":"failed","id":"2"}`),
+}
+
+func TestEventsPublishedWithMsgId(t *testing.T) {
+	natsServer := startNatsServer(t, &server.Options{})
+	jetStream := addStream(t, natsServer, &nats.StreamConfig{})
+
+	err := NewNats(configOf(natsSettings(natsServer))).Push(natsItems)
+
+	assert.Nil(t, err)
+	first, _ := jetStream.GetMsg("MAILGUN", 1)
+	second, _ := jetStream.GetMsg("MAILGUN", 2)
+	assert.Equal(t, "mailgun.mg.example.com.delivered", first.Subject)
+	assert.Equal(t, "1", first.Header.Get(nats.MsgIdHdr))
+	assert.Equal(t, string(natsItems[0]), string(first.Data))
+	assert.Equal(t, "mailgun.mg.example.com.failed", second.Subject)
+	assert.Equal(t, "2", second.Header.Get(nats.MsgIdHdr))
+}
+
+func TestEventWithoutIdPublishedWithoutMsgId(t *testing.T) {
+	natsServer := startNatsServer(t, &server.Options{})
+	jetStream := addStream(t, natsServer, &nats.StreamConfig{})
+	items := []json.RawMessage{json.RawMessage(`{"event":"delivered"}`), json.RawMessage(`{"event":"delivered"}`)}
+
+	err := NewNats(configOf(natsSettings(natsServer))).Push(items)
+
+	assert.Nil(t, err)
+	message, _ := jetStream.GetMsg("MAILGUN", 2)
+	assert.Equal(t, "", message.Header.Get(nats.MsgIdHdr))
+}
+
+func TestPagePublishedAgainDeduplicated(t *testing.T) {
+	natsServer := startNatsServer(t, &server.Options{})
+	jetStream := addStream(t, natsServer, &nats.StreamConfig{Duplicates: time.Minute})
+	config := configOf(natsSettings(natsServer))
+
+	assert.Nil(t, NewNats(config).Push(natsItems))
+	assert.Nil(t, NewNats(config).Push(natsItems))
+
+	info, _ := jetStream.StreamInfo("MAILGUN")
+	assert.Equal(t, uint64(2), info.State.Msgs)
+}
+
+func TestJetStreamErrorFails(t *testing.T) {
+	natsServer := startNatsServer(t, &server.Options{})
+	addStream(t, natsServer, &nats.StreamConfig{MaxMsgs: 1, Discard: nats.DiscardNew})
+
+	err := NewNats(configOf(natsSettings(natsServer))).Push(natsItems)
+
+	if err == nil || !strings.Contains(err.Error(), "JetStream rejected the event.") {
+		t.Errorf("Rejected event expected. %s", err)
+	}
+}
+
+func TestPublishWithoutStreamFails(t *testing.T) {
+	natsServer := startNatsServer(t, &server.Options{})
+
+	err := NewNats(configOf(natsSettings(natsServer))).Push(natsItems)
+
+	assert.EqualError(t, err, "No JetStream stream listens on the subject.")
+}
+
+func TestCoreNatsPublishFlushed(t *testing.T) {
+	natsServer := startNatsServer(t, &server.Options{})
+	subscriber, _ := nats.Connect(natsServer.ClientURL())
+	defer subscriber.Close()
+	messages := make(chan *nats.Msg, 2)
+	subscriber.ChanSubscribe("events.*", messages)
+	subscriber.Flush()
+
+	err := NewNats(configOf(natsSettings(natsServer), map[string]string{
+		"NATS_JETSTREAM": "false",
+		"NATS_SUBJECT":   "events.{event}",
+	})).Push(natsItems)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "events.delivered", (<-messages).Subject)
+	assert.Equal(t, "events.failed", (<-messages).Subject)
+}
+
+func TestNatsTokenAuthentication(t *testing.T) {
+	natsServer := startNatsServer(t, &server.Options{Authorization: "secret"})
+	config := natsSettings(natsServer)
+	config["NATS_JETSTREAM"] = "false"
+
+	config["NATS_TOKEN"] = "secret"
+	assert.Nil(t, NewNats(configOf(config)).Push(natsItems))
+
+	config["NATS_TOKEN"] = "wrong"
+	assert.Panics(t, func() {
+		NewNats(configOf(config))
+	})
+}
@@ -0,0 +1,196 @@
+package pusher
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/http2"
+	"io/ioutil"
+	"matchwork/mailgun-log-fetcher/event"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const otlpHttpPath = "/v1/logs"
+const otlpGrpcPath = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+const defaultServiceName = "mglogfetch"
+
+// otlpSeverities maps syslog severities to OpenTelemetry severity numbers.
+var otlpSeverities = map[int]struct {
+	number uint64
+	text   string
+}{
+	event.SeverityError:         {17, "ERROR"},
+	event.SeverityWarning:       {13, "WARN"},
+	event.SeverityInformational: {9, "INFO"},
+}
+
+// OtlpPusher exports events as OpenTelemetry log records over OTLP/HTTP with
+// protobuf or over OTLP/gRPC.
+type OtlpPusher struct {
+	endpoint    *httpEndpoint
+	grpcClient  *http.Client
+	grpcUrl     string
+	resource    protoMessage
+	summaryBody bool
+	batchSize   int
+}
+
+func init() {
+	Register("otlp", NewOtlp)
+}
+
+func NewOtlp(config Config) PusherInterface {
+	protocol := config("OTLP_PROTOCOL")
+	if protocol != "" && protocol != "http/protobuf" && protocol != "grpc" {
+		panic(fmt.Sprintf("Unknown OTLP protocol: %s", protocol))
+	}
+	body := config("OTLP_BODY")
+	if body != "" && body != "json" && body != "summary" {
+		panic(fmt.Sprintf("Unknown OTLP body: %s", body))
+	}
+	serviceName := config("OTLP_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	pusher := &OtlpPusher{
+		endpoint: newHttpEndpoint(config, "OTLP_"),
+		resource: protoMessage{}.
+			Message(1, stringAttribute("service.name", serviceName)).
+			Message(1, stringAttribute("host.name", config("LOG_HOSTNAME"))).
+			Message(1, stringAttribute("mailgun.domain", config("MAIL_DOMAIN"))),
+		summaryBody: body == "summary",
+		batchSize:   batchSize(config("OTLP_BATCH_SIZE")),
+	}
+	if protocol == "grpc" {
+		pusher.grpcUrl = strings.TrimSuffix(config("OTLP_URL"), "/") + otlpGrpcPath
+		pusher.grpcClient = grpcClient(strings.HasPrefix(pusher.grpcUrl, "http://"), tlsConfig(config, "OTLP_TLS_"))
+	}
+	return pusher
+}
+
+// grpcClient speaks HTTP/2 over TLS, or in plain text (h2c) for http URLs.
+func grpcClient(plainText bool, tlsConfig *tls.Config) *http.Client {
+	transport := &http2.Transport{TLSClientConfig: tlsConfig}
+	if plainText {
+		transport.AllowHTTP = true
+		transport.DialTLS = func(network, address string, config *tls.Config) (net.Conn, error) {
+			return net.Dial(network, address)
+		}
+	}
+	return &http.Client{Transport: transport, Timeout: time.Minute}
+}
+
+func (p *OtlpPusher) Push(items []json.RawMessage) error {
+	for _, batch := range batches(items, p.batchSize) {
+		request := p.exportRequest(batch)
+		var err error
+		if p.grpcClient != nil {
+			err = p.exportGrpc(request)
+		} else {
+			_, err = p.endpoint.post(otlpHttpPath, request, "application/x-protobuf")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close aborts a push in progress.
+func (p *OtlpPusher) Close() error {
+	p.endpoint.close()
+	return nil
+}
+
+// exportRequest writes an ExportLogsServiceRequest with one resource and scope.
+func (p *OtlpPusher) exportRequest(items []json.RawMessage) protoMessage {
+	scopeLogs := protoMessage{}.Message(1, protoMessage{}.String(1, "mailgun"))
+	for _, item := range items {
+		scopeLogs = scopeLogs.Message(2, p.logRecord(item))
+	}
+	resourceLogs := protoMessage{}.Message(1, p.resource).Message(2, scopeLogs)
+	return protoMessage{}.Message(1, resourceLogs)
+}
+
+func (p *OtlpPusher) logRecord(item json.RawMessage) protoMessage {
+	e := event.Parse(item)
+	document := event.Decode(item)
+	severity := otlpSeverities[e.Severity()]
+
+	body := string(item)
+	if p.summaryBody {
+		body = strings.TrimSpace(e.Event + " " + e.Recipient)
+	}
+
+	record := protoMessage{}.
+		Fixed64(1, uint64(e.Time().UnixNano())).
+		Varint(2, severity.number).
+		String(3, severity.text).
+		Message(5, protoMessage{}.String(1, body))
+	attributes := map[string]string{
+		"mailgun.event":      e.Event,
+		"mailgun.id":         e.ID,
+		"mailgun.recipient":  e.Recipient,
+		"mailgun.message_id": event.LookupString(document, "message.headers.message-id"),
+	}
+	for _, key := range []string{"mailgun.event", "mailgun.id", "mailgun.recipient", "mailgun.message_id"} {
+		if attributes[key] != "" {
+			record = record.Message(6, stringAttribute(key, attributes[key]))
+		}
+	}
+	if tags, ok := document["tags"].([]interface{}); ok && len(tags) > 0 {
+		var values protoMessage
+		for _, tag := range tags {
+			values = values.Message(1, protoMessage{}.String(1, fmt.Sprint(tag)))
+		}
+		record = record.Message(6, protoMessage{}.String(1, "mailgun.tags").Message(2, protoMessage{}.Message(5, values)))
+	}
+	return record
+}
+
+func stringAttribute(key string, value string) protoMessage {
+	return protoMessage{}.String(1, key).Message(2, protoMessage{}.String(1, value))
+}
+
+// exportGrpc sends the request as a unary gRPC call, the result is in the
+// grpc-status trailer.
+func (p *OtlpPusher) exportGrpc(request protoMessage) error {
+	frame := make([]byte, 5, 5+len(request))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(request)))
+	frame = append(frame, request...)
+
+	httpRequest, err := http.NewRequestWithContext(p.endpoint.context, "POST", p.grpcUrl, bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	for name, values := range p.endpoint.headers {
+		httpRequest.Header[name] = values
+	}
+	httpRequest.Header.Set("Content-Type", "application/grpc")
+	httpRequest.Header.Set("TE", "trailers")
+
+	response, err := p.grpcClient.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	ioutil.ReadAll(response.Body)
+
+	if response.StatusCode != 200 {
+		return fmt.Errorf("Statuscode was %d.", response.StatusCode)
+	}
+	status := response.Trailer.Get("grpc-status")
+	if status == "" {
+		status = response.Header.Get("grpc-status")
+	}
+	if status != "0" {
+		return fmt.Errorf("gRPC status was %s. %s", status, response.Trailer.Get("grpc-message"))
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package pusher
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type protoField struct {
+	number int
+	varint uint64
+	bytes  []byte
+}
+
+// decodeProto reads the top level fields of a protobuf message.
+func decodeProto(t *testing.T, message []byte) map[int][]protoField {
+	fields := map[int][]protoField{}
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		message = message[n:]
+		field := protoField{number: int(key >> 3)}
+		switch key & 7 {
+		case wireVarint:
+			field.varint, n = binary.Uvarint(message)
+			message = message[n:]
+		case wireFixed64:
+			field.varint = binary.LittleEndian.Uint64(message)
+			message = message[8:]
+		case wireBytes:
+			length, n := binary.Uvarint(message)
+			field.bytes = message[n : n+int(length)]
+			message = message[n+int(length):]
+		default:
+			t.Fatalf("Unexpected wire type %d", key&7)
+		}
+		fields[field.number] = append(fields[field.number], field)
+	}
+	return fields
+}
+
+var otlpItem = json.RawMessage(`{"id":"LMbm5AS1S8SQDHvUE-VGug","event":"failed","severity":"permanent","recipient":"info@example.com",` +
+	`"timestamp":1636532679.442269,"tags":["welcome"],"message":{"headers":{"message-id":"20211110082439.c2c2aae2c82c7083@mg.example.com"}}}`)
+
+func assertExportRequest(t *testing.T, request []byte, expectedBody string) {
+	resourceLogs := decodeProto(t, decodeProto(t, request)[1][0].bytes)
+	resource := decodeProto(t, resourceLogs[1][0].bytes)
+	if len(resource[1]) != 3 || string(resource[1][0].bytes) != string(stringAttribute("service.name", "mglogfetch")) {
+		t.Errorf("Resource attributes expected.")
+	}
+
+	scopeLogs := decodeProto(t, resourceLogs[2][0].bytes)
+	record := decodeProto(t, scopeLogs[2][0].bytes)
+	if record[1][0].varint != 1636532679442269000 {
+		t.Errorf("Event time expected, got %d", record[1][0].varint)
+	}
+	if record[2][0].varint != 17 || string(record[3][0].bytes) != "ERROR" {
+		t.Errorf("Error severity expected.")
+	}
+	if body := decodeProto(t, record[5][0].bytes)[1][0].bytes; string(body) != expectedBody {
+		t.Errorf("Failed asserting body %s is equal with %s", body, expectedBody)
+	}
+
+	attributes := map[string]bool{}
+	for _, attribute := range record[6] {
+		attributes[string(decodeProto(t, attribute.bytes)[1][0].bytes)] = true
+	}
+	for _, key := range []string{"mailgun.event", "mailgun.id", "mailgun.recipient", "mailgun.message_id", "mailgun.tags"} {
+		if !attributes[key] {
+			t.Errorf("Attribute %s expected.", key)
+		}
+	}
+}
+
+var otlpSettings = map[string]string{
+	"LOG_HOSTNAME":                  "somehost",
+	"MAIL_DOMAIN":                   "mg.example.com",
+	"OTLP_TLS_INSECURE_SKIP_VERIFY": "true",
+}
+
+func TestLogsExportedOverOtlpHttp(t *testing.T) {
+	collector := &fakeCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	err := NewOtlp(configOf(otlpSettings, map[string]string{"OTLP_URL": server.URL})).Push([]json.RawMessage{otlpItem})
+
+	assertNoErrors(t, err)
+	if collector.requests[0].header.Get("Content-Type") != "application/x-protobuf" {
+		t.Errorf("Protobuf content type expected.")
+	}
+	assertExportRequest(t, []byte(collector.requests[0].body), string(otlpItem))
+}
+
+func grpcCollector(t *testing.T, status string, requests chan []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.URL.Path != otlpGrpcPath || r.Header.Get("Content-Type") != "application/grpc" {
+			t.Errorf("gRPC request expected, got %s %s", r.Proto, r.URL.Path)
+		}
+		frame, _ := ioutil.ReadAll(r.Body)
+		requests <- frame[5:]
+		w.Header().Set("Trailer", "grpc-status, grpc-message")
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("grpc-status", status)
+		w.Header().Set("grpc-message", "status "+status)
+	}
+}
+
+func TestLogsExportedOverOtlpGrpc(t *testing.T) {
+	requests := make(chan []byte, 1)
+	server := httptest.NewUnstartedServer(grpcCollector(t, "0", requests))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	err := NewOtlp(configOf(otlpSettings, map[string]string{"OTLP_URL": server.URL, "OTLP_PROTOCOL": "grpc", "OTLP_BODY": "summary"})).Push([]json.RawMessage{otlpItem})
+
+	assertNoErrors(t, err)
+	assertExportRequest(t, <-requests, "failed info@example.com")
+}
+
+func TestFailedGrpcStatusFailsPush(t *testing.T) {
+	requests := make(chan []byte, 1)
+	server := httptest.NewUnstartedServer(grpcCollector(t, "14", requests))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	err := NewOtlp(configOf(otlpSettings, map[string]string{"OTLP_URL": server.URL, "OTLP_PROTOCOL": "grpc"})).Push([]json.RawMessage{otlpItem})
+
+	if err == nil || !strings.Contains(err.Error(), "gRPC status was 14") {
+		t.Errorf("gRPC status error expected. %s", err)
+	}
+}
+
+func TestLogsExportedOverPlainTextGrpc(t *testing.T) {
+	requests := make(chan []byte, 1)
+	server := httptest.NewServer(h2c.NewHandler(grpcCollector(t, "0", requests), &http2.Server{}))
+	defer server.Close()
+
+	err := NewOtlp(configOf(otlpSettings, map[string]string{"OTLP_URL": server.URL, "OTLP_PROTOCOL": "grpc"})).Push([]json.RawMessage{otlpItem})
+
+	assertNoErrors(t, err)
+	assertExportRequest(t, <-requests, string(otlpItem))
+}
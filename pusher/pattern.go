@@ -0,0 +1,16 @@
+package pusher
+
+import (
+	"matchwork/mailgun-log-fetcher/event"
+	"strings"
+)
+
+// expandPattern fills the {domain} and {event} placeholders of a tag,
+// topic or subject pattern like mailgun.{domain}.{event}.
+func expandPattern(pattern string, domain string, e event.Event) string {
+	eventType := e.Event
+	if eventType == "" {
+		eventType = "unknown"
+	}
+	return strings.NewReplacer("{domain}", domain, "{event}", eventType).Replace(pattern)
+}
@@ -0,0 +1,61 @@
+package pusher
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+const wireVarint = 0
+const wireFixed64 = 1
+const wireBytes = 2
+
+// protoMessage encodes a protobuf message field by field, enough for the
+// push APIs without generated code. Empty values are skipped as proto3 does.
+type protoMessage []byte
+
+func (m protoMessage) uvarint(value uint64) protoMessage {
+	buffer := make([]byte, binary.MaxVarintLen64)
+	return append(m, buffer[:binary.PutUvarint(buffer, value)]...)
+}
+
+func (m protoMessage) key(field int, wireType int) protoMessage {
+	return m.uvarint(uint64(field<<3 | wireType))
+}
+
+func (m protoMessage) Varint(field int, value uint64) protoMessage {
+	if value == 0 {
+		return m
+	}
+	return m.key(field, wireVarint).uvarint(value)
+}
+
+func (m protoMessage) Fixed64(field int, value uint64) protoMessage {
+	if value == 0 {
+		return m
+	}
+	buffer := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buffer, value)
+	return append(m.key(field, wireFixed64), buffer...)
+}
+
+func (m protoMessage) Double(field int, value float64) protoMessage {
+	return m.Fixed64(field, math.Float64bits(value))
+}
+
+func (m protoMessage) Bytes(field int, value []byte) protoMessage {
+	if len(value) == 0 {
+		return m
+	}
+	m = m.key(field, wireBytes).uvarint(uint64(len(value)))
+	return append(m, value...)
+}
+
+func (m protoMessage) String(field int, value string) protoMessage {
+	return m.Bytes(field, []byte(value))
+}
+
+// Message embeds a message, it is written even when empty.
+func (m protoMessage) Message(field int, value protoMessage) protoMessage {
+	m = m.key(field, wireBytes).uvarint(uint64(len(value)))
+	return append(m, value...)
+}
@@ -0,0 +1,26 @@
+package pusher
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestProtobufFieldsEncoded(t *testing.T) {
+	message := protoMessage{}.
+		Varint(1, 150).
+		String(2, "testing").
+		Message(3, protoMessage{}.Varint(1, 150)).
+		Double(4, 1).
+		Varint(5, 0).
+		String(6, "")
+
+	expected := []byte{
+		0x08, 0x96, 0x01,
+		0x12, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g',
+		0x1a, 0x03, 0x08, 0x96, 0x01,
+		0x21, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f,
+	}
+	if !bytes.Equal(message, expected) {
+		t.Errorf("Failed asserting %x is equal with %x", []byte(message), expected)
+	}
+}
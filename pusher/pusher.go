@@ -4,7 +4,10 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,6 +15,9 @@ var now = func() TimeInterface {
 	return time.Now()
 }
 
+const defaultMaxDatagramSize = 1024
+const truncatedMarker = "..."
+
 type TimeInterface interface {
 	Format(layout string) string
 }
@@ -20,34 +26,130 @@ type PusherInterface interface {
 	Push(items []json.RawMessage) error
 }
 
+// CloserInterface is implemented by sinks which can abort a push in
+// progress, like a fan-out sink timing out.
+type CloserInterface interface {
+	Close() error
+}
+
 type ConnInterface interface {
 	Write(b []byte) (int, error)
 	Close() error
 }
 
 type Pusher struct {
-	connection ConnInterface
+	connection      ConnInterface
+	datagram        bool
+	maxDatagramSize int
+	format          *syslogFormat
+	host            syslogHost
 }
 
-func New() PusherInterface {
-	config := &tls.Config{}
-	con, err := tls.Dial("tcp", os.Getenv("REMOTE_LOG_HOST"), config)
+// New creates a syslog sink connected to REMOTE_LOG_HOST.
+func New(config Config) PusherInterface {
+	network, address := parseRemoteHost(config("REMOTE_LOG_HOST"))
+	format := newSyslogFormat(config)
+
+	if network == "relp" || network == "relp+tls" {
+		return newRelp(network, address, format, config)
+	}
+
+	var con net.Conn
+	var err error
+	switch network {
+	case "tls":
+		con, err = tls.Dial("tcp", address, tlsConfig(config, "REMOTE_LOG_TLS_"))
+	case "tcp", "udp", "unix", "unixgram":
+		con, err = net.Dial(network, address)
+	default:
+		panic(fmt.Sprintf("Unsupported remote log host scheme: %s", network))
+	}
 	if err != nil {
 		panic("Failed to connect to remote host.")
 	}
-	return &Pusher{connection: con}
+
+	if network == "udp" || network == "unixgram" {
+		return &Pusher{connection: con, datagram: true, maxDatagramSize: maxDatagramSize(config), format: format, host: newSyslogHost(config)}
+	}
+	return &Pusher{connection: con, format: format, host: newSyslogHost(config)}
+}
+
+// parseRemoteHost splits a REMOTE_LOG_HOST value like udp://host:514,
+// relp://host:2514 or unixgram:///dev/log into network and address. A value
+// without scheme is dialed over TLS.
+func parseRemoteHost(remoteHost string) (string, string) {
+	parts := strings.SplitN(remoteHost, "://", 2)
+	if len(parts) == 1 {
+		return "tls", remoteHost
+	}
+	return parts[0], parts[1]
+}
+
+func maxDatagramSize(config Config) int {
+	size, err := strconv.Atoi(config("UDP_MAX_DATAGRAM_SIZE"))
+	if err != nil || size <= 0 {
+		return defaultMaxDatagramSize
+	}
+	return size
+}
+
+// syslogHost is the hostname and app name of the syslog header, read from
+// the settings of the sink, so fan-out sinks can have their own.
+type syslogHost struct {
+	hostname string
+	domain   string
+}
+
+func newSyslogHost(config Config) syslogHost {
+	return syslogHost{hostname: config("LOG_HOSTNAME"), domain: config("MAIL_DOMAIN")}
+}
+
+// header is the RFC5424 header put before every event.
+func (h syslogHost) header() []byte {
+	hostnameTagPid := fmt.Sprintf("%s %s %d", h.hostname, h.domain, os.Getpid())
+	return []byte(fmt.Sprintf("<80>1 %s %s - - ", now().Format(time.RFC3339), hostnameTagPid))
 }
 
 func (p *Pusher) Push(items []json.RawMessage) error {
-	hostnameTagPid := fmt.Sprintf("%s %s %d", os.Getenv("LOG_HOSTNAME"), os.Getenv("MAIL_DOMAIN"), os.Getpid())
-	syslogFields := []byte(fmt.Sprintf("<80>1 %s %s - - ", now().Format(time.RFC3339), hostnameTagPid))
+	syslogFields := p.host.header()
 
 	for _, item := range items {
-		item = append(syslogFields, item...)
-		p.connection.Write(item)
-		p.connection.Write([]byte("\n"))
+		item = p.format.lineOf(syslogFields, item)
+		if err := p.write(item); err != nil {
+			p.connection.Close()
+			return err
+		}
 	}
 	time.Sleep(100 * time.Millisecond)
 	p.connection.Close()
 	return nil
 }
+
+func (p *Pusher) write(message []byte) error {
+	if p.datagram {
+		_, err := p.connection.Write(truncate(message, p.maxDatagramSize))
+		return err
+	}
+	if _, err := p.connection.Write(message); err != nil {
+		return err
+	}
+	_, err := p.connection.Write([]byte("\n"))
+	return err
+}
+
+// Close aborts a push in progress.
+func (p *Pusher) Close() error {
+	return p.connection.Close()
+}
+
+// truncate cuts a message to fit into one datagram, marking the cut at its end.
+func truncate(message []byte, size int) []byte {
+	if len(message) <= size {
+		return message
+	}
+	if size <= len(truncatedMarker) {
+		return message[:size]
+	}
+	truncated := append([]byte{}, message[:size-len(truncatedMarker)]...)
+	return append(truncated, truncatedMarker...)
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"matchwork/mailgun-log-fetcher/utils"
 	"os"
 	"testing"
@@ -37,7 +38,7 @@ func TestItemsPushedToHostAndPortWithTlsWithRealConnection(t *testing.T) {
 	config := &tls.Config{InsecureSkipVerify: true}
 	con, _ := tls.Dial("tcp", os.Getenv("REMOTE_LOG_HOST"), config)
 
-	pusher := Pusher{connection: con}
+	pusher := Pusher{connection: con, host: newSyslogHost(os.Getenv)}
 	ok := pusher.Push(items)
 
 	assertNoErrors(t, ok)
@@ -87,3 +88,131 @@ func waitUntilServerIsReady(done chan bool) {
 		ready = <-done
 	}
 }
+
+func TestItemsPushedWithPlainTcp(t *testing.T) {
+	utils.InitTestEnv()
+	var items []json.RawMessage
+	mockNow := new(MockNow)
+	mockNow.On("Format", time.RFC3339).Once()
+	now = func() TimeInterface {
+		return mockNow
+	}
+
+	ln, _ := net.Listen("tcp", "localhost:0")
+	defer ln.Close()
+	received := make(chan []byte)
+	go func() {
+		con, _ := ln.Accept()
+		content, _ := ioutil.ReadAll(con)
+		received <- content
+	}()
+
+	t.Setenv("REMOTE_LOG_HOST", "tcp://"+ln.Addr().String())
+
+	json.Unmarshal([]byte(itemsAsString), &items)
+	ok := New(os.Getenv).Push(items)
+
+	assertNoErrors(t, ok)
+	content := <-received
+	if !bytes.Equal(content, prepareExpectedItems(items)) {
+		t.Errorf("Failed asserting tcp output %s", content)
+	}
+}
+
+func TestItemsPushedWithUdpOnePerDatagram(t *testing.T) {
+	utils.InitTestEnv()
+	var items []json.RawMessage
+	mockNow := new(MockNow)
+	mockNow.On("Format", time.RFC3339).Once()
+	now = func() TimeInterface {
+		return mockNow
+	}
+
+	con, _ := net.ListenPacket("udp", "localhost:0")
+	defer con.Close()
+
+	t.Setenv("REMOTE_LOG_HOST", "udp://"+con.LocalAddr().String())
+	t.Setenv("UDP_MAX_DATAGRAM_SIZE", "2048")
+
+	json.Unmarshal([]byte(itemsAsString), &items)
+	expectedItems := getExpectedItems(items)
+	ok := New(os.Getenv).Push(items)
+	assertNoErrors(t, ok)
+
+	buffer := make([]byte, 65536)
+	for _, expected := range expectedItems {
+		con.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := con.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("Datagram expected. %s", err)
+		}
+		if !bytes.Equal(buffer[:n], expected) {
+			t.Errorf("Failed asserting datagram %s is equal with %s", buffer[:n], expected)
+		}
+	}
+}
+
+func TestItemsPushedToUnixDatagramSocket(t *testing.T) {
+	utils.InitTestEnv()
+	var items []json.RawMessage
+	mockNow := new(MockNow)
+	mockNow.On("Format", time.RFC3339).Once()
+	now = func() TimeInterface {
+		return mockNow
+	}
+
+	socket := t.TempDir() + "/log"
+	con, _ := net.ListenPacket("unixgram", socket)
+	defer con.Close()
+
+	t.Setenv("REMOTE_LOG_HOST", "unixgram://"+socket)
+	t.Setenv("UDP_MAX_DATAGRAM_SIZE", "8192")
+
+	json.Unmarshal([]byte(itemsAsString), &items)
+	expectedItems := getExpectedItems(items)
+	ok := New(os.Getenv).Push(items)
+	assertNoErrors(t, ok)
+
+	buffer := make([]byte, 65536)
+	for _, expected := range expectedItems {
+		con.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := con.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("Datagram expected. %s", err)
+		}
+		if !bytes.Equal(buffer[:n], expected) {
+			t.Errorf("Failed asserting datagram %s is equal with %s", buffer[:n], expected)
+		}
+	}
+}
+
+func TestItemsPushedToUnixStreamSocket(t *testing.T) {
+	utils.InitTestEnv()
+	var items []json.RawMessage
+	mockNow := new(MockNow)
+	mockNow.On("Format", time.RFC3339).Once()
+	now = func() TimeInterface {
+		return mockNow
+	}
+
+	socket := t.TempDir() + "/log.sock"
+	ln, _ := net.Listen("unix", socket)
+	defer ln.Close()
+	received := make(chan []byte)
+	go func() {
+		con, _ := ln.Accept()
+		content, _ := ioutil.ReadAll(con)
+		received <- content
+	}()
+
+	t.Setenv("REMOTE_LOG_HOST", "unix://"+socket)
+
+	json.Unmarshal([]byte(itemsAsString), &items)
+	ok := New(os.Getenv).Push(items)
+
+	assertNoErrors(t, ok)
+	content := <-received
+	if !bytes.Equal(content, prepareExpectedItems(items)) {
+		t.Errorf("Failed asserting unix socket output %s", content)
+	}
+}
@@ -2,6 +2,7 @@ package pusher
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/mock"
 	"matchwork/mailgun-log-fetcher/utils"
@@ -170,19 +171,98 @@ func TestItemsPushedToHostAndPortWithTls(t *testing.T) {
 		On("Write", []byte("\n")).Once()
 	mockConn.On("Close").Once()
 
-	pusher := Pusher{mockConn}
+	pusher := Pusher{connection: mockConn, host: newSyslogHost(os.Getenv)}
 	ok := pusher.Push(items)
 
 	assertNoErrors(t, ok)
 	mockConn.AssertExpectations(t)
 }
 
+func TestItemsPushedOnePerDatagram(t *testing.T) {
+	utils.InitTestEnv()
+	var items []json.RawMessage
+
+	json.Unmarshal([]byte(itemsAsString), &items)
+	expectedItems := getExpectedItems(items)
+	mockNow := new(MockNow)
+	mockNow.On("Format", time.RFC3339).Once()
+	now = func() TimeInterface {
+		return mockNow
+	}
+
+	mockConn := new(MockConn)
+	mockConn.
+		On("Write", truncatedItem(expectedItems[0], 97)).Once().
+		On("Write", truncatedItem(expectedItems[1], 97)).Once().
+		On("Write", truncatedItem(expectedItems[2], 97)).Once()
+	mockConn.On("Close").Once()
+
+	pusher := Pusher{connection: mockConn, datagram: true, maxDatagramSize: 100, host: newSyslogHost(os.Getenv)}
+	ok := pusher.Push(items)
+
+	assertNoErrors(t, ok)
+	mockConn.AssertExpectations(t)
+}
+
+type brokenConn struct {
+	closed bool
+}
+
+func (c *brokenConn) Write(b []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func (c *brokenConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestFailedWriteFailsPush(t *testing.T) {
+	now = func() TimeInterface { return time.Now() }
+	connection := &brokenConn{}
+	pusher := Pusher{connection: connection, host: newSyslogHost(os.Getenv)}
+
+	err := pusher.Push([]json.RawMessage{json.RawMessage(`{"id":"1"}`)})
+
+	if err == nil || err.Error() != "broken pipe" || !connection.closed {
+		t.Errorf("Write error expected and connection closed. %s", err)
+	}
+}
+
+func TestRemoteHostSchemeSelectsNetwork(t *testing.T) {
+	cases := map[string][]string{
+		"logs.example.com:6514":       {"tls", "logs.example.com:6514"},
+		"tls://logs.example.com:6514": {"tls", "logs.example.com:6514"},
+		"tcp://10.0.0.1:514":          {"tcp", "10.0.0.1:514"},
+		"udp://10.0.0.1:514":          {"udp", "10.0.0.1:514"},
+		"unix:///run/rsyslog.sock":    {"unix", "/run/rsyslog.sock"},
+		"unixgram:///dev/log":         {"unixgram", "/dev/log"},
+	}
+
+	for remoteHost, expected := range cases {
+		network, address := parseRemoteHost(remoteHost)
+		if network != expected[0] || address != expected[1] {
+			t.Errorf("Expected %s %s for %s, got %s %s", expected[0], expected[1], remoteHost, network, address)
+		}
+	}
+}
+
+func TestShortMessageIsNotTruncated(t *testing.T) {
+	if string(truncate([]byte("short"), 100)) != "short" {
+		t.Errorf("Short message changed.")
+	}
+}
+
 func assertNoErrors(t *testing.T, ok error) {
 	if ok != nil {
 		t.Errorf("Ok expected for result.")
 	}
 }
 
+func truncatedItem(item json.RawMessage, length int) []byte {
+	return append(append([]byte{}, item[:length]...), "..."...)
+}
+
 func getExpectedItems(items []json.RawMessage) []json.RawMessage {
 	message := fmt.Sprintf("<80>1 %s %s %s %d - - ", nowString, os.Getenv("LOG_HOSTNAME"), os.Getenv("MAIL_DOMAIN"), os.Getpid())
 	var decoratedItems []json.RawMessage
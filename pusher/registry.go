@@ -0,0 +1,58 @@
+package pusher
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const defaultSink = "syslog"
+
+// Config looks up a configuration value by key, the same way os.Getenv does.
+type Config func(key string) string
+
+// Constructor creates a sink from its configuration.
+type Constructor func(config Config) PusherInterface
+
+var sinks = map[string]Constructor{}
+
+func init() {
+	Register(defaultSink, New)
+}
+
+// Register makes a sink type available by name for Create. Sink types
+// register themselves from an init function of their own file.
+func Register(name string, constructor Constructor) {
+	if _, exists := sinks[name]; exists {
+		panic(fmt.Sprintf("Sink %s is already registered.", name))
+	}
+	sinks[name] = constructor
+}
+
+func Create(name string, config Config) PusherInterface {
+	constructor, ok := sinks[name]
+	if !ok {
+		panic(fmt.Sprintf("Unknown sink: %s", name))
+	}
+	return constructor(config)
+}
+
+// FromEnv creates the sink named by the SINK env var, syslog by default.
+func FromEnv() PusherInterface {
+	name := os.Getenv("SINK")
+	if name == "" {
+		name = defaultSink
+	}
+	return Create(name, os.Getenv)
+}
+
+// TryPush creates a sink and pushes the items, turning a panic of the sink,
+// like a failed connection, into an error.
+func TryPush(create func() PusherInterface, items []json.RawMessage) (err error) {
+	defer func() {
+		if f := recover(); f != nil {
+			err = fmt.Errorf("%v", f)
+		}
+	}()
+	return create().Push(items)
+}
@@ -0,0 +1,78 @@
+package pusher
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type fakeSink struct {
+	config Config
+}
+
+func (s *fakeSink) Push(items []json.RawMessage) error {
+	return nil
+}
+
+// configOf looks the settings of a test up in the maps, later maps win.
+func configOf(settings ...map[string]string) Config {
+	return func(key string) string {
+		for index := len(settings) - 1; index >= 0; index-- {
+			if value, ok := settings[index][key]; ok {
+				return value
+			}
+		}
+		return ""
+	}
+}
+
+func init() {
+	Register("fake", func(config Config) PusherInterface {
+		return &fakeSink{config: config}
+	})
+}
+
+func TestSinkCreatedByName(t *testing.T) {
+	config := func(key string) string {
+		return "value of " + key
+	}
+
+	sink := Create("fake", config).(*fakeSink)
+
+	if sink.config("KEY") != "value of KEY" {
+		t.Errorf("Sink config expected.")
+	}
+}
+
+func TestSinkSelectedFromEnv(t *testing.T) {
+	t.Setenv("SINK", "fake")
+	t.Setenv("FAKE_SETTING", "on")
+
+	sink, ok := FromEnv().(*fakeSink)
+
+	if !ok || sink.config("FAKE_SETTING") != "on" {
+		t.Errorf("Fake sink with env config expected.")
+	}
+}
+
+func TestUnknownSinkFailed(t *testing.T) {
+	defer func() {
+		f := recover()
+		if f == nil || !strings.Contains(f.(string), "Unknown sink: missing") {
+			t.Errorf("Unknown sink panic expected. %s", f)
+		}
+	}()
+
+	Create("missing", nil)
+}
+
+func TestSinkRegisteredTwiceFailed(t *testing.T) {
+	defer func() {
+		f := recover()
+		if f == nil || !strings.Contains(f.(string), "already registered") {
+			t.Errorf("Duplicate registration panic expected. %s", f)
+		}
+	}()
+
+	Register("syslog", New)
+}
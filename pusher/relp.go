@@ -0,0 +1,300 @@
+package pusher
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRelpWindow = 128
+const defaultRelpTimeout = 30 * time.Second
+const maxRelpTxnr = 999999999
+
+// maxRelpFrameBytes bounds the data of a frame read from the server, its
+// responses are short. maxRelpTokenBytes bounds the txnr, command and length.
+const maxRelpFrameBytes = 128 * 1024
+const maxRelpTokenBytes = 32
+const relpOffer = "relp_version=0\nrelp_software=mglogfetch\ncommands=syslog"
+
+type relpFrame struct {
+	txnr    int
+	command string
+	data    []byte
+}
+
+type relpMessage struct {
+	txnr    int
+	message []byte
+}
+
+// RelpPusher sends syslog messages with the Reliable Event Logging Protocol.
+// Every message is acknowledged by the receiver, messages of a broken
+// connection not acknowledged yet are sent again on a new connection.
+type RelpPusher struct {
+	dial         func() (net.Conn, error)
+	connection   net.Conn
+	reader       *bufio.Reader
+	txnr         int
+	window       int
+	timeout      time.Duration
+	reconnectMax int
+	format       *syslogFormat
+	host         syslogHost
+
+	mutex  sync.Mutex
+	closed bool
+}
+
+func newRelp(network string, address string, format *syslogFormat, config Config) PusherInterface {
+	pusher := &RelpPusher{
+		format:       format,
+		host:         newSyslogHost(config),
+		window:       defaultRelpWindow,
+		timeout:      durationOr(config("RELP_TIMEOUT"), defaultRelpTimeout),
+		reconnectMax: defaultRetryMax,
+	}
+	if window, err := strconv.Atoi(config("RELP_WINDOW")); err == nil && window > 0 {
+		pusher.window = window
+	}
+	if reconnectMax, err := strconv.Atoi(config("RELP_RECONNECT_MAX")); err == nil {
+		pusher.reconnectMax = reconnectMax
+	}
+
+	pusher.dial = func() (net.Conn, error) {
+		if network == "relp+tls" {
+			return tls.Dial("tcp", address, tlsConfig(config, "REMOTE_LOG_TLS_"))
+		}
+		return net.Dial("tcp", address)
+	}
+	if err := pusher.connect(); err != nil {
+		panic(fmt.Sprintf("Failed to connect to remote host. %s", err))
+	}
+	return pusher
+}
+
+func (p *RelpPusher) Push(items []json.RawMessage) error {
+	defer p.disconnect()
+
+	syslogFields := p.host.header()
+	var unsent [][]byte
+	for _, item := range items {
+		unsent = append(unsent, p.format.lineOf(syslogFields, item))
+	}
+
+	var inFlight []relpMessage
+	reconnects := 0
+	for len(unsent) > 0 || len(inFlight) > 0 {
+		err := p.exchange(&unsent, &inFlight)
+		if err == nil {
+			continue
+		}
+		if _, rejected := err.(*relpRejectedError); rejected || reconnects >= p.reconnectMax {
+			return err
+		}
+
+		reconnects++
+		p.connection.Close()
+		if err := p.connect(); err != nil {
+			return err
+		}
+		for index := len(inFlight) - 1; index >= 0; index-- {
+			unsent = append([][]byte{inFlight[index].message}, unsent...)
+		}
+		inFlight = nil
+	}
+
+	p.close()
+	return nil
+}
+
+// exchange fills the window with unsent messages, then waits for a response.
+func (p *RelpPusher) exchange(unsent *[][]byte, inFlight *[]relpMessage) error {
+	for len(*unsent) > 0 && len(*inFlight) < p.window {
+		txnr, err := p.send("syslog", (*unsent)[0])
+		if err != nil {
+			return err
+		}
+		*inFlight = append(*inFlight, relpMessage{txnr: txnr, message: (*unsent)[0]})
+		*unsent = (*unsent)[1:]
+	}
+
+	response, err := p.receive()
+	if err != nil {
+		return err
+	}
+	for index, message := range *inFlight {
+		if message.txnr == response.txnr {
+			*inFlight = append((*inFlight)[:index], (*inFlight)[index+1:]...)
+			return checkRelpResponse(response)
+		}
+	}
+	return fmt.Errorf("Unexpected RELP response %d.", response.txnr)
+}
+
+func (p *RelpPusher) connect() error {
+	con, err := p.dial()
+	if err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		con.Close()
+		return errors.New("RELP session closed.")
+	}
+	p.connection = con
+	p.mutex.Unlock()
+	p.reader = bufio.NewReader(con)
+	p.txnr = 0
+
+	txnr, err := p.send("open", []byte(relpOffer))
+	if err != nil {
+		return err
+	}
+	response, err := p.receive()
+	if err != nil {
+		return err
+	}
+	if response.txnr != txnr {
+		return fmt.Errorf("Unexpected RELP response %d to open.", response.txnr)
+	}
+	return checkRelpResponse(response)
+}
+
+// close ends the session, the receiver answers and sends serverclose.
+func (p *RelpPusher) close() {
+	if _, err := p.send("close", nil); err == nil {
+		p.receive()
+	}
+}
+
+func (p *RelpPusher) disconnect() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.connection != nil {
+		p.connection.Close()
+	}
+}
+
+// Close aborts a push in progress, the session is not reopened.
+func (p *RelpPusher) Close() error {
+	p.mutex.Lock()
+	p.closed = true
+	p.mutex.Unlock()
+	p.disconnect()
+	return nil
+}
+
+func (p *RelpPusher) send(command string, data []byte) (int, error) {
+	p.txnr++
+	if p.txnr > maxRelpTxnr {
+		p.txnr = 1
+	}
+	_, err := p.connection.Write(encodeRelpFrame(relpFrame{txnr: p.txnr, command: command, data: data}))
+	return p.txnr, err
+}
+
+func (p *RelpPusher) receive() (relpFrame, error) {
+	p.connection.SetReadDeadline(time.Now().Add(p.timeout))
+	frame, err := readRelpFrame(p.reader)
+	if err != nil {
+		return frame, err
+	}
+	if frame.command == "serverclose" {
+		return frame, errors.New("RELP receiver closed the session.")
+	}
+	if frame.command != "rsp" {
+		return frame, fmt.Errorf("Unexpected RELP command %s.", frame.command)
+	}
+	return frame, nil
+}
+
+type relpRejectedError struct {
+	response string
+}
+
+func (e *relpRejectedError) Error() string {
+	return fmt.Sprintf("RELP receiver rejected the message. %s", e.response)
+}
+
+func checkRelpResponse(response relpFrame) error {
+	if !strings.HasPrefix(string(response.data), "200") {
+		return &relpRejectedError{response: string(response.data)}
+	}
+	return nil
+}
+
+func encodeRelpFrame(frame relpFrame) []byte {
+	encoded := []byte(fmt.Sprintf("%d %s %d", frame.txnr, frame.command, len(frame.data)))
+	if len(frame.data) > 0 {
+		encoded = append(append(encoded, ' '), frame.data...)
+	}
+	return append(encoded, '\n')
+}
+
+func readRelpFrame(reader *bufio.Reader) (relpFrame, error) {
+	var frame relpFrame
+	txnr, _, err := readRelpToken(reader)
+	if err != nil {
+		return frame, err
+	}
+	command, _, err := readRelpToken(reader)
+	if err != nil {
+		return frame, err
+	}
+	length, delimiter, err := readRelpToken(reader)
+	if err != nil {
+		return frame, err
+	}
+
+	frame.command = command
+	frame.txnr, err = strconv.Atoi(txnr)
+	if err != nil {
+		return frame, fmt.Errorf("Invalid RELP txnr %s.", txnr)
+	}
+	dataLength, err := strconv.Atoi(length)
+	if err != nil || dataLength < 0 || dataLength > maxRelpFrameBytes {
+		return frame, fmt.Errorf("Invalid RELP data length %s.", length)
+	}
+	if dataLength == 0 {
+		if delimiter == ' ' {
+			_, err = reader.ReadByte()
+		}
+		return frame, err
+	}
+
+	frame.data = make([]byte, dataLength)
+	if _, err := io.ReadFull(reader, frame.data); err != nil {
+		return frame, err
+	}
+	trailer, err := reader.ReadByte()
+	if err == nil && trailer != '\n' {
+		err = errors.New("Missing RELP trailer.")
+	}
+	return frame, err
+}
+
+func readRelpToken(reader *bufio.Reader) (string, byte, error) {
+	var token []byte
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return "", 0, err
+		}
+		if b == ' ' || b == '\n' {
+			return string(token), b, nil
+		}
+		if len(token) == maxRelpTokenBytes {
+			return "", 0, errors.New("Too long RELP frame header.")
+		}
+		token = append(token, b)
+	}
+}
@@ -0,0 +1,135 @@
+package pusher
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// listenAsRelp answers every open and syslog command with 200 OK. The first
+// connection is dropped on the dropAfter-th syslog message without answering
+// it, zero keeps it open.
+func listenAsRelp(t *testing.T, dropAfter int) (net.Listener, chan string) {
+	ln, _ := net.Listen("tcp", "localhost:0")
+	messages := make(chan string, 100)
+	go func() {
+		for connection := 0; ; connection++ {
+			con, err := ln.Accept()
+			if err != nil {
+				close(messages)
+				return
+			}
+			reader := bufio.NewReader(con)
+			received := 0
+			for {
+				frame, err := readRelpFrame(reader)
+				if err != nil {
+					break
+				}
+				if frame.command == "syslog" {
+					received++
+					messages <- string(frame.data)
+					if connection == 0 && received == dropAfter {
+						break
+					}
+				}
+				con.Write(encodeRelpFrame(relpFrame{txnr: frame.txnr, command: "rsp", data: []byte("200 OK")}))
+				if frame.command == "close" {
+					con.Write(encodeRelpFrame(relpFrame{command: "serverclose"}))
+					break
+				}
+			}
+			con.Close()
+		}
+	}()
+	return ln, messages
+}
+
+func TestRelpFrameRoundTrip(t *testing.T) {
+	for _, frame := range []relpFrame{
+		{txnr: 1, command: "open", data: []byte(relpOffer)},
+		{txnr: 2, command: "close"},
+		{txnr: 3, command: "syslog", data: []byte("message with\nnew line")},
+	} {
+		decoded, err := readRelpFrame(bufio.NewReader(strings.NewReader(string(encodeRelpFrame(frame)))))
+
+		assert.Nil(t, err)
+		assert.Equal(t, frame, decoded)
+	}
+	assert.Equal(t, "2 close 0\n", string(encodeRelpFrame(relpFrame{txnr: 2, command: "close"})))
+}
+
+func TestInvalidRelpFrameLengthRejected(t *testing.T) {
+	for _, frame := range []string{"1 rsp -1 x\n", "1 rsp 999999999999 x\n", "1 " + strings.Repeat("r", 100) + " 0\n"} {
+		_, err := readRelpFrame(bufio.NewReader(strings.NewReader(frame)))
+
+		assert.NotNil(t, err, frame)
+	}
+}
+
+func TestRelpMessagesAcknowledged(t *testing.T) {
+	now = func() TimeInterface { return time.Now() }
+	t.Setenv("LOG_HOSTNAME", "somehost")
+	ln, messages := listenAsRelp(t, 0)
+	defer ln.Close()
+
+	err := New(configOf(map[string]string{"REMOTE_LOG_HOST": "relp://" + ln.Addr().String()})).Push([]json.RawMessage{
+		json.RawMessage(`{"id":"1"}`),
+		json.RawMessage(`{"id":"2"}`),
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(<-messages, `{"id":"1"}`))
+	assert.True(t, strings.HasSuffix(<-messages, `{"id":"2"}`))
+}
+
+func TestRelpUnacknowledgedMessagesResent(t *testing.T) {
+	now = func() TimeInterface { return time.Now() }
+	ln, messages := listenAsRelp(t, 2)
+	defer ln.Close()
+
+	var items []json.RawMessage
+	for _, id := range []string{"1", "2", "3"} {
+		items = append(items, json.RawMessage(`{"id":"`+id+`"}`))
+	}
+	err := New(configOf(map[string]string{"REMOTE_LOG_HOST": "relp://" + ln.Addr().String()})).Push(items)
+
+	assert.Nil(t, err)
+	var received []string
+	for len(received) < 4 {
+		message := <-messages
+		received = append(received, message[strings.Index(message, "{"):])
+	}
+	assert.Equal(t, []string{`{"id":"1"}`, `{"id":"2"}`, `{"id":"2"}`, `{"id":"3"}`}, received)
+}
+
+func TestRelpRejectionFails(t *testing.T) {
+	now = func() TimeInterface { return time.Now() }
+	ln, _ := net.Listen("tcp", "localhost:0")
+	defer ln.Close()
+	go func() {
+		con, _ := ln.Accept()
+		defer con.Close()
+		reader := bufio.NewReader(con)
+		for {
+			frame, err := readRelpFrame(reader)
+			if err != nil {
+				return
+			}
+			response := "200 OK"
+			if frame.command == "syslog" {
+				response = "500 queue full"
+			}
+			con.Write(encodeRelpFrame(relpFrame{txnr: frame.txnr, command: "rsp", data: []byte(response)}))
+		}
+	}()
+
+	err := New(configOf(map[string]string{"REMOTE_LOG_HOST": "relp://" + ln.Addr().String()})).Push([]json.RawMessage{json.RawMessage(`{"id":"1"}`)})
+
+	assert.EqualError(t, err, "RELP receiver rejected the message. 500 queue full")
+}
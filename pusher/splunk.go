@@ -0,0 +1,172 @@
+package pusher
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"matchwork/mailgun-log-fetcher/event"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultSourcetype = "mailgun:event"
+const defaultAckPollInterval = time.Second
+const defaultAckTimeout = time.Minute
+
+var splunkChannel string
+var splunkChannelOnce sync.Once
+
+type hecEvent struct {
+	Time       float64         `json:"time"`
+	Host       string          `json:"host,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	Sourcetype string          `json:"sourcetype"`
+	Index      string          `json:"index,omitempty"`
+	Event      json.RawMessage `json:"event"`
+}
+
+type hecResponse struct {
+	Text  string `json:"text"`
+	Code  int    `json:"code"`
+	AckId *int64 `json:"ackId"`
+}
+
+// SplunkPusher sends events to the Splunk HTTP Event Collector. With indexer
+// acknowledgement on, a batch is pushed only when Splunk confirms it.
+type SplunkPusher struct {
+	endpoint        *httpEndpoint
+	host            string
+	source          string
+	sourcetype      string
+	index           string
+	batchSize       int
+	ack             bool
+	ackPollInterval time.Duration
+	ackTimeout      time.Duration
+}
+
+func init() {
+	Register("splunk", NewSplunk)
+}
+
+func NewSplunk(config Config) PusherInterface {
+	endpoint := newHttpEndpoint(config, "SPLUNK_HEC_")
+	endpoint.headers.Set("Authorization", "Splunk "+config("SPLUNK_HEC_TOKEN"))
+
+	pusher := &SplunkPusher{
+		endpoint:        endpoint,
+		host:            config("LOG_HOSTNAME"),
+		source:          config("MAIL_DOMAIN"),
+		sourcetype:      config("SPLUNK_SOURCETYPE"),
+		index:           config("SPLUNK_INDEX"),
+		batchSize:       batchSize(config("SPLUNK_BATCH_SIZE")),
+		ack:             config("SPLUNK_ACK") == "true",
+		ackPollInterval: durationOr(config("SPLUNK_ACK_POLL_INTERVAL"), defaultAckPollInterval),
+		ackTimeout:      durationOr(config("SPLUNK_ACK_TIMEOUT"), defaultAckTimeout),
+	}
+	if pusher.sourcetype == "" {
+		pusher.sourcetype = defaultSourcetype
+	}
+	if pusher.ack {
+		endpoint.headers.Set("X-Splunk-Request-Channel", channel(config("SPLUNK_CHANNEL")))
+	}
+	return pusher
+}
+
+func durationOr(value string, defaultValue time.Duration) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+	return duration
+}
+
+// channel is the configured channel, or one generated for the life of the
+// process, as acknowledgements are tracked by channel.
+func channel(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	splunkChannelOnce.Do(func() {
+		id := make([]byte, 16)
+		rand.Read(id)
+		id[6] = id[6]&0x0f | 0x40
+		id[8] = id[8]&0x3f | 0x80
+		splunkChannel = fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+	})
+	return splunkChannel
+}
+
+func (p *SplunkPusher) Push(items []json.RawMessage) error {
+	for _, batch := range batches(items, p.batchSize) {
+		if err := p.pushBatch(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close aborts a push in progress.
+func (p *SplunkPusher) Close() error {
+	p.endpoint.close()
+	return nil
+}
+
+func (p *SplunkPusher) pushBatch(batch []json.RawMessage) error {
+	var body []byte
+	for _, item := range batch {
+		envelope, _ := json.Marshal(hecEvent{
+			Time:       event.Parse(item).Timestamp,
+			Host:       p.host,
+			Source:     p.source,
+			Sourcetype: p.sourcetype,
+			Index:      p.index,
+			Event:      item,
+		})
+		body = append(body, envelope...)
+	}
+
+	responseBody, err := p.endpoint.post("/services/collector/event", body, "application/json")
+	if err != nil {
+		return err
+	}
+	var response hecResponse
+	json.Unmarshal(responseBody, &response)
+	if response.Code != 0 {
+		return fmt.Errorf("HEC failed with code %d. %s", response.Code, response.Text)
+	}
+
+	if !p.ack {
+		return nil
+	}
+	if response.AckId == nil {
+		return fmt.Errorf("HEC acknowledgement is not enabled for the token.")
+	}
+	return p.waitForAck(*response.AckId)
+}
+
+// waitForAck polls the ack endpoint until the batch is indexed.
+func (p *SplunkPusher) waitForAck(ackId int64) error {
+	request, _ := json.Marshal(map[string][]int64{"acks": {ackId}})
+	deadline := time.Now().Add(p.ackTimeout)
+
+	for {
+		responseBody, err := p.endpoint.post("/services/collector/ack", request, "application/json")
+		if err != nil {
+			return err
+		}
+		var response struct {
+			Acks map[string]bool `json:"acks"`
+		}
+		json.Unmarshal(responseBody, &response)
+		if response.Acks[strconv.FormatInt(ackId, 10)] {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("HEC acknowledgement %d timed out after %s.", ackId, p.ackTimeout)
+		}
+		sleep(p.ackPollInterval)
+	}
+}
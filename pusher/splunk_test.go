@@ -0,0 +1,129 @@
+package pusher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeHec struct {
+	sync.Mutex
+	events       []string
+	channels     []string
+	ackPolls     int
+	pendingPolls int
+}
+
+func (h *fakeHec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Lock()
+	defer h.Unlock()
+	if r.Header.Get("Authorization") != "Splunk token" {
+		w.WriteHeader(401)
+		fmt.Fprint(w, `{"text":"Invalid token","code":4}`)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	h.channels = append(h.channels, r.Header.Get("X-Splunk-Request-Channel"))
+
+	if r.URL.Path == "/services/collector/ack" {
+		h.ackPolls++
+		fmt.Fprintf(w, `{"acks":{"7":%t}}`, h.ackPolls > h.pendingPolls)
+		return
+	}
+	h.events = append(h.events, string(body))
+	fmt.Fprint(w, `{"text":"Success","code":0,"ackId":7}`)
+}
+
+var splunkItems = []json.RawMessage{
+	json.RawMessage(`{"id":"1","timestamp":1636532241.823582}`),
+	json.RawMessage(`{"id":"2","timestamp":1636532679.442269}`),
+}
+
+var splunkSettings = map[string]string{
+	"SPLUNK_HEC_TOKEN":         "token",
+	"LOG_HOSTNAME":             "somehost",
+	"MAIL_DOMAIN":              "mg.example.com",
+	"SPLUNK_ACK_POLL_INTERVAL": "1ms",
+}
+
+func TestEventsSentInHecEnvelope(t *testing.T) {
+	hec := &fakeHec{}
+	server := httptest.NewServer(hec)
+	defer server.Close()
+
+	err := NewSplunk(configOf(splunkSettings, map[string]string{"SPLUNK_HEC_URL": server.URL, "SPLUNK_INDEX": "mail"})).Push(splunkItems)
+
+	assertNoErrors(t, err)
+	expected := `{"time":1636532241.823582,"host":"somehost","source":"mg.example.com","sourcetype":"mailgun:event","index":"mail","event":{"id":"1","timestamp":1636532241.823582}}` +
+		`{"time":1636532679.442269,"host":"somehost","source":"mg.example.com","sourcetype":"mailgun:event","index":"mail","event":{"id":"2","timestamp":1636532679.442269}}`
+	if len(hec.events) != 1 || hec.events[0] != expected {
+		t.Errorf("Failed asserting %v is equal with %s", hec.events, expected)
+	}
+	if hec.ackPolls != 0 {
+		t.Errorf("No ack poll expected without ack.")
+	}
+}
+
+func TestPushWaitsForIndexerAcknowledgement(t *testing.T) {
+	noSleep(t)
+	hec := &fakeHec{pendingPolls: 2}
+	server := httptest.NewServer(hec)
+	defer server.Close()
+
+	err := NewSplunk(configOf(splunkSettings, map[string]string{"SPLUNK_HEC_URL": server.URL, "SPLUNK_ACK": "true", "SPLUNK_SOURCETYPE": "mailgun"})).Push(splunkItems)
+
+	assertNoErrors(t, err)
+	if hec.ackPolls != 3 {
+		t.Errorf("Polls until ack expected, got %d", hec.ackPolls)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(hec.channels[0]) {
+		t.Errorf("Generated channel expected, got %s", hec.channels[0])
+	}
+	for _, channel := range hec.channels {
+		if channel != hec.channels[0] {
+			t.Errorf("Same channel expected for the requests.")
+		}
+	}
+	if !strings.Contains(hec.events[0], `"sourcetype":"mailgun"`) {
+		t.Errorf("Configured sourcetype expected.")
+	}
+}
+
+func TestMissingAcknowledgementFailsPush(t *testing.T) {
+	noSleep(t)
+	hec := &fakeHec{pendingPolls: 1000000}
+	server := httptest.NewServer(hec)
+	defer server.Close()
+
+	err := NewSplunk(configOf(splunkSettings, map[string]string{
+		"SPLUNK_HEC_URL":     server.URL,
+		"SPLUNK_ACK":         "true",
+		"SPLUNK_CHANNEL":     "channel",
+		"SPLUNK_ACK_TIMEOUT": "10ms",
+	})).Push(splunkItems)
+
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Ack timeout expected. %s", err)
+	}
+	if hec.channels[0] != "channel" {
+		t.Errorf("Configured channel expected.")
+	}
+}
+
+func TestInvalidHecTokenFailsPush(t *testing.T) {
+	hec := &fakeHec{}
+	server := httptest.NewServer(hec)
+	defer server.Close()
+
+	err := NewSplunk(configOf(splunkSettings, map[string]string{"SPLUNK_HEC_URL": server.URL, "SPLUNK_HEC_TOKEN": "wrong"})).Push(splunkItems)
+
+	if err == nil || !strings.Contains(err.Error(), "Statuscode was 401") {
+		t.Errorf("Token error expected. %s", err)
+	}
+}
@@ -0,0 +1,67 @@
+package pusher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+const formatJson = "json"
+const formatSyslog = "syslog"
+
+var stdout io.Writer = os.Stdout
+var stderr io.Writer = os.Stderr
+
+// StreamPusher writes one event per line to a stream, for log agents which
+// collect the output of the container.
+type StreamPusher struct {
+	out          io.Writer
+	format       string
+	syslogFormat *syslogFormat
+	host         syslogHost
+}
+
+func init() {
+	Register("stdout", func(config Config) PusherInterface {
+		return NewStream(stdout, config("STDOUT_FORMAT"), config)
+	})
+	Register("stderr", func(config Config) PusherInterface {
+		return NewStream(stderr, config("STDERR_FORMAT"), config)
+	})
+}
+
+// NewStream creates a sink writing to out in the format, json lines by
+// default or syslog for the same lines the syslog sink sends.
+func NewStream(out io.Writer, format string, config Config) PusherInterface {
+	if format == "" {
+		format = formatJson
+	}
+	if format != formatJson && format != formatSyslog {
+		panic(fmt.Sprintf("Unknown stream format: %s", format))
+	}
+	pusher := &StreamPusher{out: out, format: format, host: newSyslogHost(config)}
+	if format == formatSyslog {
+		pusher.syslogFormat = newSyslogFormat(config)
+	}
+	return pusher
+}
+
+func (p *StreamPusher) Push(items []json.RawMessage) error {
+	var prefix []byte
+	if p.format == formatSyslog {
+		prefix = p.host.header()
+	}
+
+	for _, item := range items {
+		line := append([]byte{}, item...)
+		if p.format == formatSyslog {
+			line = p.syslogFormat.lineOf(prefix, item)
+		}
+		line = append(line, '\n')
+		if _, err := p.out.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
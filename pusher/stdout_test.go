@@ -0,0 +1,110 @@
+package pusher
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"matchwork/mailgun-log-fetcher/utils"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type failingWriter struct{}
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("closed pipe")
+}
+
+func TestEventsWrittenAsJsonLines(t *testing.T) {
+	out := &bytes.Buffer{}
+	items := []json.RawMessage{json.RawMessage(`{"id":"1"}`), json.RawMessage(`{"id":"2"}`)}
+
+	err := NewStream(out, "", os.Getenv).Push(items)
+
+	assertNoErrors(t, err)
+	if out.String() != "{\"id\":\"1\"}\n{\"id\":\"2\"}\n" {
+		t.Errorf("Json lines expected, got %s", out.String())
+	}
+}
+
+func TestEventsWrittenInSyslogFormat(t *testing.T) {
+	utils.InitTestEnv()
+	mockNow := new(MockNow)
+	mockNow.On("Format", time.RFC3339).Once()
+	now = func() TimeInterface {
+		return mockNow
+	}
+	out := &bytes.Buffer{}
+
+	err := NewStream(out, "syslog", os.Getenv).Push([]json.RawMessage{json.RawMessage(`{"id":"1"}`)})
+
+	assertNoErrors(t, err)
+	expected := fmt.Sprintf("<80>1 %s %s %s %d - - {\"id\":\"1\"}\n", nowString, os.Getenv("LOG_HOSTNAME"), os.Getenv("MAIL_DOMAIN"), os.Getpid())
+	if out.String() != expected {
+		t.Errorf("Failed asserting %s is equal with %s", out.String(), expected)
+	}
+}
+
+func TestSyslogHeaderReadFromSinkSettings(t *testing.T) {
+	now = func() TimeInterface { return time.Now() }
+	out := &bytes.Buffer{}
+	config := prefixed(configOf(map[string]string{"SIEM_LOG_HOSTNAME": "siemhost", "SIEM_MAIL_DOMAIN": "siem.example.com"}), "siem")
+
+	NewStream(out, "syslog", config).Push([]json.RawMessage{json.RawMessage(`{"id":"1"}`)})
+
+	if !strings.Contains(out.String(), fmt.Sprintf(" siemhost siem.example.com %d - - {", os.Getpid())) {
+		t.Errorf("Hostname and domain of the sink expected, got %s", out.String())
+	}
+}
+
+func TestStdoutSinkRegistered(t *testing.T) {
+	out := &bytes.Buffer{}
+	stdout = out
+	defer func() {
+		stdout = os.Stdout
+	}()
+
+	Create("stdout", configOf(map[string]string{"STDOUT_FORMAT": "json"})).Push([]json.RawMessage{json.RawMessage(`{}`)})
+
+	if out.String() != "{}\n" {
+		t.Errorf("Event expected on stdout.")
+	}
+}
+
+func TestStderrSinkReadsItsOwnFormat(t *testing.T) {
+	now = func() TimeInterface { return time.Now() }
+	out := &bytes.Buffer{}
+	stderr = out
+	defer func() {
+		stderr = os.Stderr
+	}()
+	config := configOf(map[string]string{"STDOUT_FORMAT": "syslog", "STDERR_FORMAT": "json"})
+
+	Create("stderr", config).Push([]json.RawMessage{json.RawMessage(`{}`)})
+
+	if out.String() != "{}\n" {
+		t.Errorf("Json line expected on stderr, got %s", out.String())
+	}
+}
+
+func TestFailedWriteReturned(t *testing.T) {
+	err := NewStream(&failingWriter{}, "json", os.Getenv).Push([]json.RawMessage{json.RawMessage(`{}`)})
+
+	if err == nil {
+		t.Errorf("Write error expected.")
+	}
+}
+
+func TestUnknownStreamFormatFailed(t *testing.T) {
+	defer func() {
+		f := recover()
+		if f == nil || !strings.Contains(f.(string), "Unknown stream format") {
+			t.Errorf("Format panic expected. %s", f)
+		}
+	}()
+
+	NewStream(&bytes.Buffer{}, "xml", os.Getenv)
+}
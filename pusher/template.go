@@ -0,0 +1,129 @@
+package pusher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"matchwork/mailgun-log-fetcher/event"
+	"text/template"
+	"time"
+)
+
+// sampleEvent is rendered at the start to validate the templates.
+var sampleEvent = json.RawMessage(`{"id":"sample","event":"delivered","timestamp":1636532734.023108,"recipient":"sample@example.com","recipient-domain":"example.com","tags":["sample"],"user-variables":{},"delivery-status":{"code":250,"message":"OK"},"message":{"headers":{"to":"sample@example.com","from":"sender@example.com","subject":"Sample","message-id":"sample@example.com"},"size":1}}`)
+
+// templateData is what SYSLOG_MSG_TEMPLATE and SYSLOG_LINE_TEMPLATE render,
+// Message is the rendered MSG part for the line template.
+type templateData struct {
+	Event    map[string]interface{}
+	Time     time.Time
+	Header   string
+	Message  string
+	Hostname string
+	Domain   string
+}
+
+// syslogFormat renders syslog lines, the header followed by the event JSON
+// without templates.
+type syslogFormat struct {
+	message *template.Template
+	line    *template.Template
+	host    syslogHost
+}
+
+var templateFunctions = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+	"field": func(path string, document map[string]interface{}) string {
+		return event.LookupString(document, path)
+	},
+	"time": func(layout string, value time.Time) string {
+		return value.Format(layout)
+	},
+	"truncate": func(length int, value string) string {
+		runes := []rune(value)
+		if len(runes) <= length {
+			return value
+		}
+		if length <= len(truncatedMarker) {
+			return string(runes[:length])
+		}
+		return string(runes[:length-len(truncatedMarker)]) + truncatedMarker
+	},
+	"default": func(defaultValue string, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return defaultValue
+		}
+		return value
+	},
+}
+
+// newSyslogFormat parses the templates and renders a sample event with them,
+// so a broken template stops the start instead of the first push.
+func newSyslogFormat(config Config) *syslogFormat {
+	format := &syslogFormat{
+		message: parseTemplate("SYSLOG_MSG_TEMPLATE", config("SYSLOG_MSG_TEMPLATE")),
+		line:    parseTemplate("SYSLOG_LINE_TEMPLATE", config("SYSLOG_LINE_TEMPLATE")),
+		host:    newSyslogHost(config),
+	}
+	if _, err := format.render([]byte("<80>1 - - - - - - "), sampleEvent); err != nil {
+		panic(fmt.Sprintf("Invalid syslog template. %s", err))
+	}
+	return format
+}
+
+func parseTemplate(name string, text string) *template.Template {
+	if text == "" {
+		return nil
+	}
+	parsed, err := template.New(name).Funcs(templateFunctions).Parse(text)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid syslog template. %s", err))
+	}
+	return parsed
+}
+
+// lineOf is the syslog line of the event. An event the templates fail on is
+// logged and sent in the default format.
+func (f *syslogFormat) lineOf(header []byte, item json.RawMessage) []byte {
+	line, err := f.render(header, item)
+	if err != nil {
+		log.Printf("Syslog template failed, sending the event as it is. %s", err)
+		return append(append([]byte{}, header...), item...)
+	}
+	return line
+}
+
+func (f *syslogFormat) render(header []byte, item json.RawMessage) ([]byte, error) {
+	if f == nil || f.message == nil && f.line == nil {
+		return append(append([]byte{}, header...), item...), nil
+	}
+
+	data := templateData{
+		Event:    event.Decode(item),
+		Time:     event.Parse(item).Time(),
+		Header:   string(header),
+		Message:  string(item),
+		Hostname: f.host.hostname,
+		Domain:   f.host.domain,
+	}
+	if f.message != nil {
+		var message bytes.Buffer
+		if err := f.message.Execute(&message, data); err != nil {
+			return nil, err
+		}
+		data.Message = message.String()
+	}
+	if f.line == nil {
+		return append([]byte(data.Header), data.Message...), nil
+	}
+
+	var line bytes.Buffer
+	if err := f.line.Execute(&line, data); err != nil {
+		return nil, err
+	}
+	return line.Bytes(), nil
+}
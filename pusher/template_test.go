@@ -0,0 +1,66 @@
+package pusher
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var templateItem = json.RawMessage(`{"id":"1","event":"failed","timestamp":1636532734.023108,"recipient":"a@example.com","delivery-status":{"code":550,"message":"Mailbox does not exist"},"tags":["invoice"]}`)
+
+func TestDefaultFormatIsHeaderAndEvent(t *testing.T) {
+	line := newSyslogFormat(configOf()).lineOf([]byte("<80>1 header "), templateItem)
+
+	assert.Equal(t, "<80>1 header "+string(templateItem), string(line))
+}
+
+func TestMessageRenderedByTemplate(t *testing.T) {
+	format := newSyslogFormat(configOf(map[string]string{
+		"SYSLOG_MSG_TEMPLATE": `{{.Event.event}} {{field "delivery-status.code" .Event}} {{field "recipient" .Event}} ` +
+			`{{field "delivery-status.message" .Event | truncate 10}} {{default "-" (field "subject" .Event)}} ` +
+			`{{time "15:04:05.000" .Time}} {{json .Event.tags}}`,
+	}))
+
+	line := format.lineOf([]byte("<80>1 header "), templateItem)
+
+	assert.Equal(t, `<80>1 header failed 550 a@example.com Mailbox... - 08:25:34.023 ["invoice"]`, string(line))
+}
+
+func TestLineRenderedByTemplate(t *testing.T) {
+	format := newSyslogFormat(configOf(map[string]string{
+		"LOG_HOSTNAME":         "somehost",
+		"SYSLOG_MSG_TEMPLATE":  `{{.Event.event}}`,
+		"SYSLOG_LINE_TEMPLATE": `{{.Hostname}} {{.Message}} {{.Event.id}}`,
+	}))
+
+	assert.Equal(t, "somehost failed 1", string(format.lineOf([]byte("<80>1 header "), templateItem)))
+}
+
+func TestTemplateHostReadFromSinkSettings(t *testing.T) {
+	t.Setenv("LOG_HOSTNAME", "globalhost")
+	t.Setenv("MAIL_DOMAIN", "global.example.com")
+	format := newSyslogFormat(prefixed(configOf(map[string]string{
+		"SIEM_LOG_HOSTNAME":    "siemhost",
+		"SIEM_MAIL_DOMAIN":     "mg.example.com",
+		"SYSLOG_LINE_TEMPLATE": `{{.Hostname}} {{.Domain}} {{.Event.id}}`,
+	}), "siem"))
+
+	assert.Equal(t, "siemhost mg.example.com 1", string(format.lineOf(nil, templateItem)))
+}
+
+func TestInvalidTemplatePanicsAtStart(t *testing.T) {
+	assert.PanicsWithValue(t, `Invalid syslog template. template: SYSLOG_MSG_TEMPLATE:1: function "unknown" not defined`, func() {
+		newSyslogFormat(configOf(map[string]string{"SYSLOG_MSG_TEMPLATE": `{{unknown .Event}}`}))
+	})
+	assert.Panics(t, func() {
+		newSyslogFormat(configOf(map[string]string{"SYSLOG_LINE_TEMPLATE": `{{truncate "ten" .Message}}`}))
+	})
+}
+
+func TestFailingTemplateSendsEventAsItIs(t *testing.T) {
+	format := newSyslogFormat(configOf(map[string]string{"SYSLOG_MSG_TEMPLATE": `{{index .Event.tags 0}}`}))
+
+	assert.Equal(t, "invoice", string(format.lineOf(nil, templateItem)))
+	assert.Equal(t, `h {"id":"2"}`, string(format.lineOf([]byte("h "), json.RawMessage(`{"id":"2"}`))))
+}
@@ -0,0 +1,83 @@
+package pusher
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsConfig builds the client TLS config from the settings starting with
+// prefix, like REMOTE_LOG_TLS_CA_FILE for the syslog sink.
+// Certificate files are read on every call, so a new connection always picks
+// up rotated certificates without restarting the process.
+func tlsConfig(config Config, prefix string) *tls.Config {
+	clientConfig := &tls.Config{
+		ServerName:         config(prefix + "SERVER_NAME"),
+		InsecureSkipVerify: config(prefix + "INSECURE_SKIP_VERIFY") == "true",
+	}
+
+	if caFile := config(prefix + "CA_FILE"); caFile != "" {
+		clientConfig.RootCAs = loadCertPool(caFile)
+	}
+
+	certFile, keyFile := config(prefix + "CERT_FILE"), config(prefix + "KEY_FILE")
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			panic(fmt.Sprintf("Failed to load client certificate. %s", err))
+		}
+		clientConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if minVersion := config(prefix + "MIN_VERSION"); minVersion != "" {
+		version, ok := tlsVersions[minVersion]
+		if !ok {
+			panic(fmt.Sprintf("Unknown TLS version: %s", minVersion))
+		}
+		clientConfig.MinVersion = version
+	}
+
+	if cipherSuites := config(prefix + "CIPHER_SUITES"); cipherSuites != "" {
+		clientConfig.CipherSuites = parseCipherSuites(cipherSuites)
+	}
+
+	return clientConfig
+}
+
+func loadCertPool(caFile string) *x509.CertPool {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to read CA file. %s", err))
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		panic(fmt.Sprintf("No certificate found in CA file %s", caFile))
+	}
+	return pool
+}
+
+func parseCipherSuites(names string) []uint16 {
+	known := map[string]uint16{}
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+
+	var ids []uint16
+	for _, name := range strings.Split(names, ",") {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			panic(fmt.Sprintf("Unknown cipher suite: %s", name))
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,216 @@
+package pusher
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"matchwork/mailgun-log-fetcher/utils"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPem     []byte
+	keyPem      []byte
+}
+
+func createCertificate(commonName string, parent *testCertificate) *testCertificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.certificate, parent.key
+	}
+
+	der, _ := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	certificate, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPem:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPem:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func (c *testCertificate) write(t *testing.T, name string) (string, string) {
+	dir := t.TempDir()
+	ioutil.WriteFile(dir+"/"+name+".crt", c.certPem, 0600)
+	ioutil.WriteFile(dir+"/"+name+".key", c.keyPem, 0600)
+	return dir + "/" + name + ".crt", dir + "/" + name + ".key"
+}
+
+func listenWithClientAuth(t *testing.T, ca *testCertificate, server *testCertificate) (net.Listener, chan string) {
+	serverCertificate, _ := tls.X509KeyPair(server.certPem, server.keyPem)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.certificate)
+	ln, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCertificate},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients := make(chan string, 2)
+	go func() {
+		for {
+			con, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			tlsCon := con.(*tls.Conn)
+			if tlsCon.Handshake() == nil {
+				clients <- tlsCon.ConnectionState().PeerCertificates[0].Subject.CommonName
+			}
+			ioutil.ReadAll(con)
+		}
+	}()
+	return ln, clients
+}
+
+func TestMutualTlsWithCustomCaAndServerName(t *testing.T) {
+	utils.InitTestEnv()
+	now = func() TimeInterface {
+		return time.Now()
+	}
+	ca := createCertificate("Test CA", nil)
+	server := createCertificate("collector.internal", ca)
+	client := createCertificate("fetcher", ca)
+
+	ln, clients := listenWithClientAuth(t, ca, server)
+	defer ln.Close()
+
+	caFile, _ := ca.write(t, "ca")
+	certFile, keyFile := client.write(t, "client")
+	t.Setenv("REMOTE_LOG_HOST", "tls://"+ln.Addr().String())
+	t.Setenv("REMOTE_LOG_TLS_CA_FILE", caFile)
+	t.Setenv("REMOTE_LOG_TLS_CERT_FILE", certFile)
+	t.Setenv("REMOTE_LOG_TLS_KEY_FILE", keyFile)
+	t.Setenv("REMOTE_LOG_TLS_SERVER_NAME", "collector.internal")
+	t.Setenv("REMOTE_LOG_TLS_MIN_VERSION", "1.2")
+
+	ok := New(os.Getenv).Push([]json.RawMessage{})
+
+	assertNoErrors(t, ok)
+	if <-clients != "fetcher" {
+		t.Errorf("Client certificate expected.")
+	}
+}
+
+func TestRotatedClientCertificateUsedForNextConnection(t *testing.T) {
+	utils.InitTestEnv()
+	now = func() TimeInterface {
+		return time.Now()
+	}
+	ca := createCertificate("Test CA", nil)
+	server := createCertificate("collector.internal", ca)
+
+	ln, clients := listenWithClientAuth(t, ca, server)
+	defer ln.Close()
+
+	caFile, _ := ca.write(t, "ca")
+	certFile, keyFile := createCertificate("first", ca).write(t, "client")
+	t.Setenv("REMOTE_LOG_HOST", "tls://"+ln.Addr().String())
+	t.Setenv("REMOTE_LOG_TLS_CA_FILE", caFile)
+	t.Setenv("REMOTE_LOG_TLS_CERT_FILE", certFile)
+	t.Setenv("REMOTE_LOG_TLS_KEY_FILE", keyFile)
+	t.Setenv("REMOTE_LOG_TLS_SERVER_NAME", "collector.internal")
+
+	New(os.Getenv).Push([]json.RawMessage{})
+	rotated := createCertificate("second", ca)
+	ioutil.WriteFile(certFile, rotated.certPem, 0600)
+	ioutil.WriteFile(keyFile, rotated.keyPem, 0600)
+	New(os.Getenv).Push([]json.RawMessage{})
+
+	if first, second := <-clients, <-clients; first != "first" || second != "second" {
+		t.Errorf("Rotated certificate expected, got %s and %s", first, second)
+	}
+}
+
+func TestUnknownServerCaRejected(t *testing.T) {
+	utils.InitTestEnv()
+	ca := createCertificate("Test CA", nil)
+	ln, _ := listenWithClientAuth(t, ca, createCertificate("collector.internal", ca))
+	defer ln.Close()
+
+	t.Setenv("REMOTE_LOG_HOST", "tls://"+ln.Addr().String())
+	t.Setenv("REMOTE_LOG_TLS_SERVER_NAME", "collector.internal")
+
+	defer func() {
+		f := recover()
+		if f == nil || !strings.Contains(f.(string), "Failed to connect") {
+			t.Errorf("Connection panic expected. %s", f)
+		}
+	}()
+
+	New(os.Getenv)
+}
+
+func TestTlsOptionsParsed(t *testing.T) {
+	t.Setenv("REMOTE_LOG_TLS_MIN_VERSION", "1.3")
+	t.Setenv("REMOTE_LOG_TLS_CIPHER_SUITES", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	t.Setenv("REMOTE_LOG_TLS_INSECURE_SKIP_VERIFY", "true")
+
+	config := tlsConfig(os.Getenv, "REMOTE_LOG_TLS_")
+
+	if config.MinVersion != tls.VersionTLS13 {
+		t.Errorf("TLS 1.3 expected as min version.")
+	}
+	if len(config.CipherSuites) != 2 || config.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("Cipher suites expected. %v", config.CipherSuites)
+	}
+	if !config.InsecureSkipVerify {
+		t.Errorf("Insecure skip verify expected.")
+	}
+}
+
+func TestUnknownTlsVersionFailed(t *testing.T) {
+	t.Setenv("REMOTE_LOG_TLS_MIN_VERSION", "2.0")
+
+	defer func() {
+		f := recover()
+		if f == nil || !strings.Contains(f.(string), "Unknown TLS version") {
+			t.Errorf("TLS version panic expected. %s", f)
+		}
+	}()
+
+	tlsConfig(os.Getenv, "REMOTE_LOG_TLS_")
+}
+
+func TestMissingCaFileFailed(t *testing.T) {
+	t.Setenv("REMOTE_LOG_TLS_CA_FILE", os.TempDir()+"/missing-ca.crt")
+
+	defer func() {
+		f := recover()
+		if f == nil || !strings.Contains(f.(string), "Failed to read CA file") {
+			t.Errorf("CA file panic expected. %s", f)
+		}
+	}()
+
+	tlsConfig(os.Getenv, "REMOTE_LOG_TLS_")
+}
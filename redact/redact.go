@@ -0,0 +1,131 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"matchwork/mailgun-log-fetcher/event"
+	"matchwork/mailgun-log-fetcher/settings"
+	"net"
+	"net/mail"
+	"strings"
+)
+
+// Redactor hashes, masks or removes personal data of the events before any
+// sink gets them. Hashes are keyed HMACs, so the same value has the same
+// hash in every event and stays joinable without being readable.
+type Redactor struct {
+	hash    []string
+	mask    []string
+	remove  []string
+	hashKey []byte
+}
+
+func New(config func(key string) string) *Redactor {
+	redactor := &Redactor{
+		hash:    settings.List(config("REDACT_HASH")),
+		mask:    settings.List(config("REDACT_MASK")),
+		remove:  settings.List(config("REDACT_REMOVE")),
+		hashKey: []byte(config("REDACT_HASH_KEY")),
+	}
+	if len(redactor.hash) > 0 && len(redactor.hashKey) == 0 {
+		panic("REDACT_HASH_KEY is required to hash fields")
+	}
+	return redactor
+}
+
+func (r *Redactor) enabled() bool {
+	return len(r.hash) > 0 || len(r.mask) > 0 || len(r.remove) > 0
+}
+
+// Apply returns the redacted events, the events themselves when nothing is
+// configured.
+func (r *Redactor) Apply(items []json.RawMessage) []json.RawMessage {
+	if !r.enabled() {
+		return items
+	}
+	redacted := make([]json.RawMessage, len(items))
+	for index, item := range items {
+		redacted[index] = r.apply(item)
+	}
+	return redacted
+}
+
+func (r *Redactor) apply(item json.RawMessage) json.RawMessage {
+	document := event.Decode(item)
+	if document == nil {
+		return item
+	}
+
+	for _, path := range r.remove {
+		event.Remove(document, path)
+	}
+	for _, path := range r.hash {
+		if value, ok := event.Lookup(document, path); ok {
+			event.Set(document, path, each(value, r.hashValue))
+		}
+	}
+	for _, path := range r.mask {
+		if value, ok := event.Lookup(document, path); ok {
+			event.Set(document, path, each(value, Mask))
+		}
+	}
+
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return item
+	}
+	return encoded
+}
+
+// each applies redact to a value or to every value of a list, like the
+// recipients of envelope.targets. Other values are redacted as JSON.
+func each(value interface{}, redact func(value string) string) interface{} {
+	switch typed := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return redact(typed)
+	case []interface{}:
+		redacted := make([]interface{}, len(typed))
+		for index, element := range typed {
+			redacted[index] = each(element, redact)
+		}
+		return redacted
+	}
+	encoded, _ := json.Marshal(value)
+	return redact(string(encoded))
+}
+
+func (r *Redactor) hashValue(value string) string {
+	mac := hmac.New(sha256.New, r.hashKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Mask keeps the first character and the domain of email addresses
+// (j***@example.com), the network of IP addresses (192.0.2.0, 2001:db8:1::)
+// and the first character of anything else.
+func Mask(value string) string {
+	if ip := net.ParseIP(value); ip != nil {
+		if ipv4 := ip.To4(); ipv4 != nil {
+			return ipv4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	}
+
+	if addresses, err := mail.ParseAddressList(value); err == nil {
+		masked := make([]string, len(addresses))
+		for index, address := range addresses {
+			at := strings.LastIndex(address.Address, "@")
+			masked[index] = string([]rune(address.Address)[0]) + "***" + address.Address[at:]
+		}
+		return strings.Join(masked, ", ")
+	}
+
+	if value == "" {
+		return value
+	}
+	return string([]rune(value)[0]) + "***"
+}
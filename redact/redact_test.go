@@ -0,0 +1,71 @@
+package redact
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var item = json.RawMessage(`{"id":"1","recipient":"jane@example.com","ip":"192.0.2.17","envelope":{"targets":"jane@example.com"},"message":{"headers":{"to":"Jane Doe <jane@example.com>","subject":"Your invoice"},"recipients":["jane@example.com","joe@example.org"]}}`)
+
+func config(values map[string]string) func(key string) string {
+	return func(key string) string {
+		return values[key]
+	}
+}
+
+func TestUnconfiguredRedactorKeepsItems(t *testing.T) {
+	items := []json.RawMessage{json.RawMessage(`{"id": "1"}`)}
+
+	assert.Equal(t, items, New(config(nil)).Apply(items))
+}
+
+func TestFieldsHashedMaskedAndRemoved(t *testing.T) {
+	redactor := New(config(map[string]string{
+		"REDACT_HASH":     "recipient,envelope.targets",
+		"REDACT_HASH_KEY": "secret",
+		"REDACT_MASK":     "ip,message.headers.to,message.recipients",
+		"REDACT_REMOVE":   "message.headers.subject",
+	}))
+
+	redacted := decoded(redactor.Apply([]json.RawMessage{item})[0])
+
+	hash := "fb817989d942e7ffb3d4b8b204f7abca29f4c25c3fa46574da84c50f30d07513"
+	assert.Equal(t, hash, redacted["recipient"])
+	assert.Equal(t, map[string]interface{}{"targets": hash}, redacted["envelope"])
+	assert.Equal(t, "192.0.2.0", redacted["ip"])
+	assert.Equal(t, map[string]interface{}{
+		"headers":    map[string]interface{}{"to": "j***@example.com"},
+		"recipients": []interface{}{"j***@example.com", "j***@example.org"},
+	}, redacted["message"])
+}
+
+func TestHashesKeyedAndStable(t *testing.T) {
+	first := New(config(map[string]string{"REDACT_HASH": "recipient", "REDACT_HASH_KEY": "secret"}))
+	other := New(config(map[string]string{"REDACT_HASH": "recipient", "REDACT_HASH_KEY": "other"}))
+
+	assert.Equal(t, first.hashValue("jane@example.com"), first.hashValue("jane@example.com"))
+	assert.NotEqual(t, first.hashValue("jane@example.com"), other.hashValue("jane@example.com"))
+	assert.Len(t, first.hashValue("jane@example.com"), 64)
+}
+
+func TestValuesMasked(t *testing.T) {
+	assert.Equal(t, "j***@example.com", Mask("jane@example.com"))
+	assert.Equal(t, "j***@example.com, b***@example.org", Mask("Jane <jane@example.com>, bob@example.org"))
+	assert.Equal(t, "2001:db8:1::", Mask("2001:db8:1:2::7"))
+	assert.Equal(t, "Y***", Mask("Your invoice"))
+	assert.Equal(t, "", Mask(""))
+}
+
+func TestHashWithoutKeyPanics(t *testing.T) {
+	assert.PanicsWithValue(t, "REDACT_HASH_KEY is required to hash fields", func() {
+		New(config(map[string]string{"REDACT_HASH": "recipient"}))
+	})
+}
+
+func decoded(item json.RawMessage) map[string]interface{} {
+	var document map[string]interface{}
+	json.Unmarshal(item, &document)
+	return document
+}
@@ -0,0 +1,32 @@
+// Package settings has the helpers shared by the packages reading their
+// settings from the environment.
+package settings
+
+import "strings"
+
+// List parses a comma separated setting, without blanks and empty values.
+func List(value string) []string {
+	var values []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// Contains tells whether value is one of values.
+func Contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Prefix is the prefix of the settings of a named sink or table, its upper
+// cased name (i.e. SIEM_ for siem, EVENT_LOG_ for event-log).
+func Prefix(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
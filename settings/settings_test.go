@@ -0,0 +1,21 @@
+package settings
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListSkipsBlanks(t *testing.T) {
+	assert.Equal(t, []string{"a", "b"}, List(" a, ,b,"))
+	assert.Nil(t, List(""))
+}
+
+func TestContains(t *testing.T) {
+	assert.True(t, Contains([]string{"a", "b"}, "b"))
+	assert.False(t, Contains(nil, "a"))
+}
+
+func TestPrefixUpperCased(t *testing.T) {
+	assert.Equal(t, "EVENT_LOG_", Prefix("event-log"))
+}
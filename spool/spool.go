@@ -0,0 +1,302 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const segmentSuffix = ".seg"
+const cursorFile = "cursor"
+const quarantineFile = "quarantine"
+
+// corruptRecords counts the records which could not be read, moved to the
+// quarantine file.
+var corruptRecords = expvar.NewInt("spool_corrupt_records")
+
+var ErrFull = errors.New("spool is full")
+
+// Spool is a durable queue of fetched pages on disk. Pages are appended as
+// JSON lines to segment files, every write is fsynced before it returns.
+// The read position is kept in the cursor file, consumed segments are deleted.
+type Spool struct {
+	mu              sync.Mutex
+	dir             string
+	segmentMaxBytes int64
+	maxBytes        int64
+	size            int64
+	writeSegment    int
+	writeSize       int64
+	writer          *os.File
+	readSegment     int
+	readOffset      int64
+	peekedLength    int64
+}
+
+func Open(dir string, segmentMaxBytes int64, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, segmentMaxBytes: segmentMaxBytes, maxBytes: maxBytes}
+
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		info, err := os.Stat(s.segmentPath(segment))
+		if err != nil {
+			return nil, err
+		}
+		s.size += info.Size()
+		s.writeSegment, s.writeSize = segment, info.Size()
+	}
+	if len(segments) > 0 {
+		s.readSegment = segments[0]
+	}
+	if err := s.loadCursor(); err != nil {
+		return nil, err
+	}
+	// A drained spool has no segment left, writing goes on at the segment
+	// of the cursor so the pages written are read.
+	if s.writeSegment < s.readSegment {
+		s.writeSegment, s.writeSize = s.readSegment, 0
+	}
+	return s, nil
+}
+
+// Write appends a page to the spool. It fails with ErrFull when the spool
+// would grow over its size limit.
+func (s *Spool) Write(items []json.RawMessage) error {
+	if len(items) == 0 {
+		return nil
+	}
+	line, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size+int64(len(line)) > s.maxBytes {
+		return ErrFull
+	}
+	if s.writer == nil || s.writeSize+int64(len(line)) > s.segmentMaxBytes {
+		if err := s.openSegment(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.writer.Write(line); err != nil {
+		return err
+	}
+	if err := s.writer.Sync(); err != nil {
+		return err
+	}
+	s.writeSize += int64(len(line))
+	s.size += int64(len(line))
+	return nil
+}
+
+// Peek returns the oldest page without removing it, false when the spool
+// is empty. The page is removed by Ack after it was delivered. A record
+// which is not a page is moved to the quarantine file and skipped.
+func (s *Spool) Peek() ([]json.RawMessage, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		line, err := s.readLine()
+		if err == nil {
+			var items []json.RawMessage
+			if err := json.Unmarshal(line, &items); err != nil {
+				if err := s.quarantine(line, err); err != nil {
+					return nil, false, err
+				}
+				continue
+			}
+			s.peekedLength = int64(len(line))
+			return items, true, nil
+		}
+		if err != io.EOF && !os.IsNotExist(err) {
+			return nil, false, err
+		}
+		if s.readSegment >= s.writeSegment {
+			return nil, false, nil
+		}
+		if err := s.removeReadSegment(); err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// Ack removes the page returned by the last Peek.
+func (s *Spool) Ack() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	length := s.peekedLength
+	s.peekedLength = 0
+	return s.skip(length)
+}
+
+// skip moves the read position over a record.
+func (s *Spool) skip(length int64) error {
+	s.readOffset += length
+	if s.readSegment == s.writeSegment && s.readOffset == s.writeSize {
+		return s.removeWriteSegment()
+	}
+	return s.saveCursor()
+}
+
+// quarantine appends a record which cannot be read to the quarantine file,
+// for inspection, and skips it.
+func (s *Spool) quarantine(line []byte, cause error) error {
+	file, err := os.OpenFile(filepath.Join(s.dir, quarantineFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(line); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	log.Printf("Corrupt record in spool segment %d at %d moved to %s. %s", s.readSegment, s.readOffset, quarantineFile, cause)
+	corruptRecords.Add(1)
+	return s.skip(int64(len(line)))
+}
+
+// removeWriteSegment frees the space of the segment being written once it is
+// consumed, the next write starts a new one.
+func (s *Spool) removeWriteSegment() error {
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+	s.writeSegment++
+	s.writeSize = 0
+	return s.removeReadSegment()
+}
+
+func (s *Spool) readLine() ([]byte, error) {
+	file, err := os.Open(s.segmentPath(s.readSegment))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(s.readOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	// A record without line end was cut by a crash before its write
+	// returned, so the page was fetched again and the record is skipped
+	// as the end of the segment.
+	return bufio.NewReader(file).ReadBytes('\n')
+}
+
+func (s *Spool) removeReadSegment() error {
+	path := s.segmentPath(s.readSegment)
+	if info, err := os.Stat(path); err == nil {
+		s.size -= info.Size()
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	s.readSegment++
+	s.readOffset = 0
+	return s.saveCursor()
+}
+
+func (s *Spool) openSegment() error {
+	if s.writer != nil {
+		s.writer.Close()
+		s.writeSegment++
+	} else if s.writeSize > 0 {
+		s.writeSegment++
+	}
+
+	writer, err := os.OpenFile(s.segmentPath(s.writeSegment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.writer = writer
+	s.writeSize = 0
+	return syncDir(s.dir)
+}
+
+func (s *Spool) segments() ([]int, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []int
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), segmentSuffix) {
+			continue
+		}
+		segment, err := strconv.Atoi(strings.TrimSuffix(file.Name(), segmentSuffix))
+		if err == nil {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+func (s *Spool) segmentPath(segment int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", segment, segmentSuffix))
+}
+
+func (s *Spool) loadCursor() error {
+	content, err := ioutil.ReadFile(filepath.Join(s.dir, cursorFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Sscanf(string(content), "%d %d", &s.readSegment, &s.readOffset)
+	return err
+}
+
+func (s *Spool) saveCursor() error {
+	temporary := filepath.Join(s.dir, cursorFile+".tmp")
+	file, err := os.Create(temporary)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(file, "%d %d", s.readSegment, s.readOffset)
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+	if err := os.Rename(temporary, filepath.Join(s.dir, cursorFile)); err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
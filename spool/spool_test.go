@@ -0,0 +1,189 @@
+package spool
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func page(ids ...string) []json.RawMessage {
+	var items []json.RawMessage
+	for _, id := range ids {
+		items = append(items, json.RawMessage(`{"id":"`+id+`"}`))
+	}
+	return items
+}
+
+func assertPage(t *testing.T, s *Spool, expected string) {
+	items, ok, err := s.Peek()
+	if err != nil || !ok {
+		t.Fatalf("Page expected. %v %s", ok, err)
+	}
+	content, _ := json.Marshal(items)
+	if string(content) != expected {
+		t.Errorf("Failed asserting page %s is equal with %s", content, expected)
+	}
+	s.Ack()
+}
+
+func assertEmpty(t *testing.T, s *Spool) {
+	if _, ok, err := s.Peek(); ok || err != nil {
+		t.Errorf("Empty spool expected. %s", err)
+	}
+}
+
+func TestPagesDrainedInOrder(t *testing.T) {
+	s, _ := Open(t.TempDir(), 1024, 1024*1024)
+
+	s.Write(page("1", "2"))
+	s.Write(page("3"))
+
+	assertPage(t, s, `[{"id":"1"},{"id":"2"}]`)
+	assertPage(t, s, `[{"id":"3"}]`)
+	assertEmpty(t, s)
+}
+
+func TestPeekWithoutAckReturnsSamePage(t *testing.T) {
+	s, _ := Open(t.TempDir(), 1024, 1024*1024)
+	s.Write(page("1"))
+
+	s.Peek()
+
+	assertPage(t, s, `[{"id":"1"}]`)
+}
+
+func TestSegmentsRotatedAndRemovedWhenConsumed(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 20, 1024*1024)
+
+	s.Write(page("1"))
+	s.Write(page("2"))
+	s.Write(page("3"))
+	segments, _ := filepath.Glob(dir + "/*.seg")
+	if len(segments) != 3 {
+		t.Errorf("Segment per page expected, %d found.", len(segments))
+	}
+
+	assertPage(t, s, `[{"id":"1"}]`)
+	assertPage(t, s, `[{"id":"2"}]`)
+	segments, _ = filepath.Glob(dir + "/*.seg")
+	if len(segments) != 2 {
+		t.Errorf("Consumed segment removal expected, %d found.", len(segments))
+	}
+}
+
+func TestFullSpoolRejectsWrite(t *testing.T) {
+	s, _ := Open(t.TempDir(), 1024, 20)
+
+	if err := s.Write(page("1")); err != nil {
+		t.Errorf("First page fits. %s", err)
+	}
+	if err := s.Write(page("2")); err != ErrFull {
+		t.Errorf("Full spool error expected. %s", err)
+	}
+
+	assertPage(t, s, `[{"id":"1"}]`)
+	assertEmpty(t, s)
+	if err := s.Write(page("2")); err != nil {
+		t.Errorf("Space expected after drain. %s", err)
+	}
+}
+
+func TestSpoolSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 20, 1024*1024)
+	s.Write(page("1"))
+	s.Write(page("2"))
+	s.Write(page("3"))
+	assertPage(t, s, `[{"id":"1"}]`)
+
+	reopened, _ := Open(dir, 20, 1024*1024)
+	reopened.Write(page("4"))
+
+	assertPage(t, reopened, `[{"id":"2"}]`)
+	assertPage(t, reopened, `[{"id":"3"}]`)
+	assertPage(t, reopened, `[{"id":"4"}]`)
+	assertEmpty(t, reopened)
+}
+
+func TestDrainedSpoolWrittenAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 1024, 1024*1024)
+	s.Write(page("1"))
+	assertPage(t, s, `[{"id":"1"}]`)
+
+	reopened, _ := Open(dir, 1024, 1024*1024)
+	reopened.Write(page("2"))
+
+	assertPage(t, reopened, `[{"id":"2"}]`)
+	assertEmpty(t, reopened)
+}
+
+func TestIncompleteRecordSkippedAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 1024, 1024*1024)
+	s.Write(page("1"))
+	segment, _ := os.OpenFile(dir+"/00000000000000000000.seg", os.O_APPEND|os.O_WRONLY, 0600)
+	segment.Write([]byte(`[{"id":"cut`))
+	segment.Close()
+
+	reopened, _ := Open(dir, 1024, 1024*1024)
+	reopened.Write(page("2"))
+
+	assertPage(t, reopened, `[{"id":"1"}]`)
+	assertPage(t, reopened, `[{"id":"2"}]`)
+	assertEmpty(t, reopened)
+}
+
+func TestCorruptRecordQuarantined(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 1024, 1024*1024)
+	s.Write(page("1"))
+	segment, _ := os.OpenFile(dir+"/00000000000000000000.seg", os.O_APPEND|os.O_WRONLY, 0600)
+	segment.Write([]byte("{\"id\":\"not a page\"}\n"))
+	segment.Close()
+	corrupt := corruptRecords.Value()
+
+	reopened, _ := Open(dir, 1024, 1024*1024)
+	reopened.Write(page("2"))
+
+	assertPage(t, reopened, `[{"id":"1"}]`)
+	assertPage(t, reopened, `[{"id":"2"}]`)
+	assertEmpty(t, reopened)
+	content, _ := ioutil.ReadFile(dir + "/quarantine")
+	if string(content) != "{\"id\":\"not a page\"}\n" {
+		t.Errorf("Corrupt record expected in quarantine, got %s", content)
+	}
+	if corruptRecords.Value() != corrupt+1 {
+		t.Errorf("Corrupt record expected to be counted.")
+	}
+}
+
+func TestConsumedSpoolLeavesNoSegment(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 1024, 1024*1024)
+	s.Write(page("1"))
+	assertPage(t, s, `[{"id":"1"}]`)
+
+	segments, _ := filepath.Glob(dir + "/*.seg")
+	if len(segments) != 0 {
+		t.Errorf("No segment expected, %d found.", len(segments))
+	}
+	s.Write(page("2"))
+	assertPage(t, s, `[{"id":"2"}]`)
+}
+
+func TestCursorPersisted(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, 1024, 1024*1024)
+	s.Write(page("1"))
+	s.Write(page("2"))
+	assertPage(t, s, `[{"id":"1"}]`)
+
+	content, _ := ioutil.ReadFile(dir + "/cursor")
+	if string(content) != "0 13" {
+		t.Errorf("Cursor after first page expected, got %s", content)
+	}
+}
@@ -0,0 +1,170 @@
+package transform
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"matchwork/mailgun-log-fetcher/event"
+	"matchwork/mailgun-log-fetcher/settings"
+	"strconv"
+	"strings"
+)
+
+var castFailures = expvar.NewInt("transform_cast_failures")
+
+type field struct {
+	path  string
+	value string
+}
+
+// Transform changes the fields of every event before it reaches the sink.
+// The stages run in order: keep, drop, rename, cast, add, flatten.
+type Transform struct {
+	keep      []string
+	drop      []string
+	rename    []field
+	cast      []field
+	add       []field
+	separator string
+}
+
+func New(config func(key string) string) *Transform {
+	transform := &Transform{
+		keep:      settings.List(config("TRANSFORM_KEEP")),
+		drop:      settings.List(config("TRANSFORM_DROP")),
+		rename:    pairs("TRANSFORM_RENAME", config("TRANSFORM_RENAME")),
+		cast:      pairs("TRANSFORM_CAST", config("TRANSFORM_CAST")),
+		add:       pairs("TRANSFORM_ADD", config("TRANSFORM_ADD")),
+		separator: config("TRANSFORM_FLATTEN_SEPARATOR"),
+	}
+	for _, cast := range transform.cast {
+		switch cast.value {
+		case "string", "int", "float", "bool":
+		default:
+			panic(fmt.Sprintf("Unknown cast type: %s", cast.value))
+		}
+	}
+	return transform
+}
+
+func (t *Transform) enabled() bool {
+	return len(t.keep) > 0 || len(t.drop) > 0 || len(t.rename) > 0 || len(t.cast) > 0 || len(t.add) > 0 || t.separator != ""
+}
+
+// Apply returns the transformed events, the events themselves when nothing
+// is configured.
+func (t *Transform) Apply(items []json.RawMessage) []json.RawMessage {
+	if !t.enabled() {
+		return items
+	}
+	transformed := make([]json.RawMessage, len(items))
+	for index, item := range items {
+		transformed[index] = t.apply(item)
+	}
+	return transformed
+}
+
+func (t *Transform) apply(item json.RawMessage) json.RawMessage {
+	document := event.Decode(item)
+	if document == nil {
+		return item
+	}
+
+	if len(t.keep) > 0 {
+		kept := map[string]interface{}{}
+		for _, path := range t.keep {
+			if value, ok := event.Lookup(document, path); ok {
+				event.Set(kept, path, value)
+			}
+		}
+		document = kept
+	}
+	for _, path := range t.drop {
+		event.Remove(document, path)
+	}
+	for _, rename := range t.rename {
+		if value, ok := event.Remove(document, rename.path); ok {
+			event.Set(document, rename.value, value)
+		}
+	}
+	for _, cast := range t.cast {
+		if value, ok := event.Lookup(document, cast.path); ok {
+			event.Set(document, cast.path, castValue(value, cast.value))
+		}
+	}
+	for _, add := range t.add {
+		event.Set(document, add.path, add.value)
+	}
+	if t.separator != "" {
+		flat := map[string]interface{}{}
+		flatten(flat, "", t.separator, document)
+		document = flat
+	}
+
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		return item
+	}
+	return encoded
+}
+
+// castValue converts value to the type, values which cannot be converted
+// are counted and left as they are.
+func castValue(value interface{}, to string) interface{} {
+	text := fmt.Sprint(value)
+	if value == nil {
+		text = ""
+	}
+	switch to {
+	case "string":
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			encoded, _ := json.Marshal(value)
+			return string(encoded)
+		}
+		return text
+	case "int":
+		if number, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return number
+		}
+		if number, err := strconv.ParseFloat(text, 64); err == nil {
+			return int64(number)
+		}
+	case "float":
+		if number, err := strconv.ParseFloat(text, 64); err == nil {
+			return number
+		}
+	case "bool":
+		if boolean, err := strconv.ParseBool(text); err == nil {
+			return boolean
+		}
+	}
+	castFailures.Add(1)
+	return value
+}
+
+func flatten(flat map[string]interface{}, prefix string, separator string, document map[string]interface{}) {
+	for key, value := range document {
+		if prefix != "" {
+			key = prefix + separator + key
+		}
+		if object, ok := value.(map[string]interface{}); ok && len(object) > 0 {
+			flatten(flat, key, separator, object)
+			continue
+		}
+		flat[key] = value
+	}
+}
+
+// pairs parses a list like recipient=to,tags=labels.
+func pairs(name string, value string) []field {
+	var fields []field
+	for _, pair := range settings.List(value) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			panic(fmt.Sprintf("Invalid %s entry: %s", name, pair))
+		}
+		fields = append(fields, field{path: strings.TrimSpace(parts[0]), value: strings.TrimSpace(parts[1])})
+	}
+	return fields
+}
@@ -0,0 +1,70 @@
+package transform
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var item = json.RawMessage(`{"id":"1","event":"delivered","recipient":"a@example.com","delivery-status":{"code":250,"attempt-no":"2","session-seconds":0.5},"message":{"headers":{"subject":"Hi"},"size":1024}}`)
+
+func config(values map[string]string) func(key string) string {
+	return func(key string) string {
+		return values[key]
+	}
+}
+
+func apply(values map[string]string) string {
+	return string(New(config(values)).Apply([]json.RawMessage{item})[0])
+}
+
+func TestUnconfiguredTransformKeepsItems(t *testing.T) {
+	items := []json.RawMessage{json.RawMessage(`{"id": "1"}`)}
+
+	assert.Equal(t, items, New(config(nil)).Apply(items))
+}
+
+func TestFieldsKeptAndDropped(t *testing.T) {
+	assert.Equal(t, `{"delivery-status":{"code":250},"id":"1","message":{"headers":{"subject":"Hi"}}}`, apply(map[string]string{
+		"TRANSFORM_KEEP": "id, delivery-status, message.headers",
+		"TRANSFORM_DROP": "delivery-status.attempt-no,delivery-status.session-seconds",
+	}))
+}
+
+func TestFieldsRenamedAndAdded(t *testing.T) {
+	assert.Equal(t, `{"id":"1","labels":{"env":"prod"},"status":{"code":250}}`, apply(map[string]string{
+		"TRANSFORM_KEEP":   "id,delivery-status.code",
+		"TRANSFORM_RENAME": "delivery-status=status",
+		"TRANSFORM_ADD":    "labels.env=prod",
+	}))
+}
+
+func TestFieldsCast(t *testing.T) {
+	assert.Equal(t, `{"delivery-status":{"attempt-no":2,"code":"250","session-seconds":0.5},"id":1}`, apply(map[string]string{
+		"TRANSFORM_KEEP": "id,delivery-status",
+		"TRANSFORM_CAST": "id=int,delivery-status.code=string,delivery-status.attempt-no=int,delivery-status.session-seconds=float",
+	}))
+}
+
+func TestFailedCastKeepsValue(t *testing.T) {
+	before := castFailures.Value()
+
+	assert.Equal(t, `{"recipient":"a@example.com"}`, apply(map[string]string{
+		"TRANSFORM_KEEP": "recipient",
+		"TRANSFORM_CAST": "recipient=bool",
+	}))
+	assert.Equal(t, before+1, castFailures.Value())
+}
+
+func TestNestedFieldsFlattened(t *testing.T) {
+	assert.Equal(t, `{"delivery-status_attempt-no":"2","delivery-status_code":250,"delivery-status_session-seconds":0.5,"event":"delivered","id":"1","message_headers_subject":"Hi","message_size":1024,"recipient":"a@example.com"}`, apply(map[string]string{
+		"TRANSFORM_FLATTEN_SEPARATOR": "_",
+	}))
+}
+
+func TestInvalidRenamePanics(t *testing.T) {
+	assert.PanicsWithValue(t, "Invalid TRANSFORM_RENAME entry: recipient", func() {
+		New(config(map[string]string{"TRANSFORM_RENAME": "recipient"}))
+	})
+}
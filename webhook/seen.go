@@ -0,0 +1,67 @@
+package webhook
+
+import (
+	"encoding/json"
+	"matchwork/mailgun-log-fetcher/event"
+	"sync"
+	"time"
+)
+
+// Seen is the set of event IDs pushed by the webhook or the polling path of
+// the hybrid mode, so each event is pushed by only one of them. IDs are
+// forgotten after the ttl.
+type Seen struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	ids    map[string]time.Time
+	pruned time.Time
+	now    func() time.Time
+}
+
+func NewSeen(ttl time.Duration) *Seen {
+	return &Seen{ttl: ttl, ids: map[string]time.Time{}, now: time.Now}
+}
+
+// Claim marks the events not seen yet as seen and returns them.
+func (s *Seen) Claim(items []json.RawMessage) []json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.prune(now)
+
+	var claimed []json.RawMessage
+	for _, item := range items {
+		id := event.Parse(item).ID
+		if expires, ok := s.ids[id]; ok && now.Before(expires) {
+			continue
+		}
+		if id != "" {
+			s.ids[id] = now.Add(s.ttl)
+		}
+		claimed = append(claimed, item)
+	}
+	return claimed
+}
+
+// Release forgets the events of a failed push, so either path can push
+// them again.
+func (s *Seen) Release(items []json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range items {
+		delete(s.ids, event.Parse(item).ID)
+	}
+}
+
+// prune drops the expired IDs, at most once a minute.
+func (s *Seen) prune(now time.Time) {
+	if now.Sub(s.pruned) < time.Minute {
+		return
+	}
+	s.pruned = now
+	for id, expires := range s.ids {
+		if now.After(expires) {
+			delete(s.ids, id)
+		}
+	}
+}
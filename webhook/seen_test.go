@@ -0,0 +1,41 @@
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeenEventsClaimedOnce(t *testing.T) {
+	seen := NewSeen(time.Hour)
+	first := json.RawMessage(`{"id":"1"}`)
+	second := json.RawMessage(`{"id":"2"}`)
+
+	assert.Equal(t, []json.RawMessage{first}, seen.Claim([]json.RawMessage{first}))
+	assert.Equal(t, []json.RawMessage{second}, seen.Claim([]json.RawMessage{first, second}))
+	assert.Empty(t, seen.Claim([]json.RawMessage{first, second}))
+}
+
+func TestReleasedEventsClaimedAgain(t *testing.T) {
+	seen := NewSeen(time.Hour)
+	items := []json.RawMessage{json.RawMessage(`{"id":"1"}`)}
+
+	seen.Claim(items)
+	seen.Release(items)
+
+	assert.Equal(t, items, seen.Claim(items))
+}
+
+func TestSeenEventsExpire(t *testing.T) {
+	current := time.Unix(1636532734, 0)
+	seen := NewSeen(time.Hour)
+	seen.now = func() time.Time { return current }
+	items := []json.RawMessage{json.RawMessage(`{"id":"1"}`)}
+
+	seen.Claim(items)
+	current = current.Add(2 * time.Hour)
+
+	assert.Equal(t, items, seen.Claim(items))
+}
@@ -0,0 +1,157 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const maxBodyBytes = 1024 * 1024
+
+type Signature struct {
+	Timestamp string `json:"timestamp"`
+	Token     string `json:"token"`
+	Signature string `json:"signature"`
+}
+
+type Payload struct {
+	Signature Signature       `json:"signature"`
+	EventData json.RawMessage `json:"event-data"`
+}
+
+// Handler receives Mailgun webhooks and pushes their event data, the same
+// events the events API returns. Mailgun retries a webhook answered with
+// an error, except for 406.
+type Handler struct {
+	signingKey []byte
+	maxAge     time.Duration
+	tokens     *tokenCache
+	push       func(items []json.RawMessage) error
+	now        func() time.Time
+}
+
+func NewHandler(signingKey string, maxAge time.Duration, push func(items []json.RawMessage) error) *Handler {
+	return &Handler{
+		signingKey: []byte(signingKey),
+		maxAge:     maxAge,
+		tokens:     &tokenCache{seen: map[string]time.Time{}},
+		push:       push,
+		now:        time.Now,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.verify(payload.Signature); err != nil {
+		log.Printf("Webhook rejected. %s", err)
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+
+	item, err := Normalize(payload.EventData)
+	if err != nil {
+		h.tokens.forget(payload.Signature.Token)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.push([]json.RawMessage{item}); err != nil {
+		log.Printf("Push of webhook failed, Mailgun will retry. %s", err)
+		h.tokens.forget(payload.Signature.Token)
+		http.Error(w, "Push failed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// verify checks the HMAC of the timestamp and token with the signing key,
+// the age of the timestamp and that the token was not used already.
+func (h *Handler) verify(signature Signature) error {
+	mac := hmac.New(sha256.New, h.signingKey)
+	mac.Write([]byte(signature.Timestamp + signature.Token))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(signature.Signature)) {
+		return errors.New("Invalid signature.")
+	}
+
+	timestamp, err := strconv.ParseInt(signature.Timestamp, 10, 64)
+	if err != nil {
+		return errors.New("Invalid timestamp.")
+	}
+	now := h.now()
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > h.maxAge || age < -h.maxAge {
+		return errors.New("Timestamp is too old.")
+	}
+
+	if !h.tokens.add(signature.Token, now, 2*h.maxAge) {
+		return errors.New("Token was used already.")
+	}
+	return nil
+}
+
+// Normalize compacts the event data like the fetcher does with the events
+// API responses.
+func Normalize(eventData json.RawMessage) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(eventData, &fields); err != nil || fields == nil {
+		return nil, errors.New("Missing event data.")
+	}
+	if _, ok := fields["id"]; !ok {
+		return nil, errors.New("Event data without id.")
+	}
+	var compacted bytes.Buffer
+	json.Compact(&compacted, eventData)
+	return compacted.Bytes(), nil
+}
+
+// tokenCache keeps the tokens of accepted webhooks until their timestamp
+// could not pass verification anymore.
+type tokenCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func (c *tokenCache) add(token string, now time.Time, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for seen, expires := range c.seen {
+		if now.After(expires) {
+			delete(c.seen, seen)
+		}
+	}
+	if _, ok := c.seen[token]; ok {
+		return false
+	}
+	c.seen[token] = now.Add(ttl)
+	return true
+}
+
+func (c *tokenCache) forget(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.seen, token)
+}
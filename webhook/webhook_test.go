@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const signingKey = "key-secret"
+
+var received = time.Unix(1636532734, 0)
+
+func sign(timestamp int64, token string) Signature {
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + token))
+	return Signature{Timestamp: strconv.FormatInt(timestamp, 10), Token: token, Signature: hex.EncodeToString(mac.Sum(nil))}
+}
+
+func post(handler http.Handler, signature Signature, eventData string) int {
+	body, _ := json.Marshal(map[string]interface{}{"signature": signature, "event-data": json.RawMessage(eventData)})
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(string(body))))
+	return recorder.Code
+}
+
+func newTestHandler(push func(items []json.RawMessage) error) *Handler {
+	handler := NewHandler(signingKey, 5*time.Minute, push)
+	handler.now = func() time.Time { return received }
+	return handler
+}
+
+func TestSignedWebhookPushed(t *testing.T) {
+	var pushed []json.RawMessage
+	handler := newTestHandler(func(items []json.RawMessage) error {
+		pushed = items
+		return nil
+	})
+
+	code := post(handler, sign(received.Unix(), "token-1"), `{ "id": "abc", "event": "delivered" }`)
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []json.RawMessage{json.RawMessage(`{"id":"abc","event":"delivered"}`)}, pushed)
+}
+
+func TestInvalidSignatureRejected(t *testing.T) {
+	handler := newTestHandler(func(items []json.RawMessage) error {
+		t.Fatal("Rejected webhook pushed")
+		return nil
+	})
+	signature := sign(received.Unix(), "token-1")
+	signature.Signature = strings.Repeat("0", 64)
+
+	assert.Equal(t, http.StatusNotAcceptable, post(handler, signature, `{"id":"abc"}`))
+}
+
+func TestOldTimestampRejected(t *testing.T) {
+	handler := newTestHandler(func(items []json.RawMessage) error { return nil })
+
+	assert.Equal(t, http.StatusNotAcceptable, post(handler, sign(received.Unix()-301, "token-1"), `{"id":"abc"}`))
+}
+
+func TestReplayedTokenRejected(t *testing.T) {
+	handler := newTestHandler(func(items []json.RawMessage) error { return nil })
+
+	assert.Equal(t, http.StatusOK, post(handler, sign(received.Unix(), "token-1"), `{"id":"abc"}`))
+	assert.Equal(t, http.StatusNotAcceptable, post(handler, sign(received.Unix(), "token-1"), `{"id":"abc"}`))
+}
+
+func TestFailedPushRetried(t *testing.T) {
+	fail := true
+	handler := newTestHandler(func(items []json.RawMessage) error {
+		if fail {
+			return errors.New("sink down")
+		}
+		return nil
+	})
+
+	assert.Equal(t, http.StatusInternalServerError, post(handler, sign(received.Unix(), "token-1"), `{"id":"abc"}`))
+	fail = false
+	assert.Equal(t, http.StatusOK, post(handler, sign(received.Unix(), "token-1"), `{"id":"abc"}`))
+}
+
+func TestWebhookWithoutEventDataRejected(t *testing.T) {
+	handler := newTestHandler(func(items []json.RawMessage) error { return nil })
+
+	assert.Equal(t, http.StatusBadRequest, post(handler, sign(received.Unix(), "token-1"), `null`))
+	assert.Equal(t, http.StatusBadRequest, post(handler, sign(received.Unix(), "token-2"), `{"event":"delivered"}`))
+}